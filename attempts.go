@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prodbygus/golang-multithreading/cep"
+)
+
+// attemptsHeader is the first row of a new -attempts-log file
+var attemptsHeader = []string{"timestamp", "cep", "provider", "outcome", "duration_ms", "http_status", "error"}
+
+// attemptLog appends a CSV row for every provider response to a file. It is
+// safe for concurrent use, so batch workers and server requests can share it.
+type attemptLog struct {
+	mu sync.Mutex
+	w  *csv.Writer
+}
+
+// openAttemptLog opens path for appending, writing the header when the file
+// is new or empty
+func openAttemptLog(path string) (*attemptLog, io.Closer, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	l := &attemptLog{w: csv.NewWriter(f)}
+	if info.Size() == 0 {
+		l.w.Write(attemptsHeader)
+		l.w.Flush()
+	}
+	return l, f, nil
+}
+
+// Observe forwards results, logging each response to the lookup of code.
+// The first success is the win; later ones lost the race.
+func (l *attemptLog) Observe(code string, results <-chan cep.Response) <-chan cep.Response {
+	out := make(chan cep.Response, cap(results))
+	go func() {
+		defer close(out)
+		won := false
+		for r := range results {
+			outcome := attemptOutcome(r, won)
+			won = won || outcome == "win"
+			l.write(code, r, outcome)
+			out <- r
+		}
+	}()
+	return out
+}
+
+// write appends the row of a single response, flushing it right away so a
+// crash loses nothing and concurrent appends never interleave
+func (l *attemptLog) write(code string, r cep.Response, outcome string) {
+	status, errText := "", ""
+	if n := httpStatus(r.Error); n != 0 {
+		status = strconv.Itoa(n)
+	}
+	if r.Error != nil {
+		errText = r.Error.Error()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.w.Write([]string{
+		time.Now().Format(time.RFC3339Nano), code, r.APIName, outcome,
+		strconv.FormatFloat(milliseconds(r.Duration), 'f', 3, 64), status, errText,
+	})
+	l.w.Flush()
+}
+
+// attemptOutcome classifies r as win, loss, not_found, timeout, canceled or
+// error
+func attemptOutcome(r cep.Response, won bool) string {
+	switch {
+	case r.Error == nil && !won:
+		return "win"
+	case r.Error == nil:
+		return "loss"
+	case errors.Is(r.Error, cep.ErrCEPNotFound):
+		return "not_found"
+	case errors.Is(r.Error, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(r.Error, context.Canceled):
+		return "canceled"
+	}
+	return "error"
+}
+
+// httpStatus returns the HTTP status behind err, or 0 when no response was
+// received. Bodies that fail to decode or report the CEP as missing (like
+// ViaCEP's {"erro": true}) came with a 200.
+func httpStatus(err error) int {
+	var statusErr *cep.HTTPStatusError
+	var notFound *cep.NotFoundError
+	var decodeErr *cep.DecodeError
+	var schemaErr *cep.SchemaError
+	switch {
+	case err == nil:
+		return http.StatusOK
+	case errors.As(err, &statusErr):
+		return statusErr.Code
+	case errors.As(err, &notFound):
+		if notFound.Body != nil {
+			return http.StatusNotFound
+		}
+		return http.StatusOK
+	case errors.As(err, &decodeErr), errors.As(err, &schemaErr):
+		return http.StatusOK
+	}
+	return 0
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// batch resolves several CEPs with at most concurrency lookups in flight.
+// Each lookup still races every provider, so up to concurrency requests can
+// hit the same provider at once. Results are handed to the formatter one at
+// a time as each lookup completes, so lookups never interleave.
+type batch struct {
+	opts        lookupOptions
+	formatter   OutputFormatter
+	concurrency int
+	output      *os.File // Receives an audit record per resolved CEP, may be nil
+
+	// timeout, when positive, bounds the whole batch on top of the timeout
+	// of each lookup; no lookup starts after it and those in flight are cut
+	// off with errDeadline. deadline, when set, does the same at an absolute
+	// time, and the earlier of the two wins.
+	timeout  time.Duration
+	deadline time.Time
+
+	// sortBy buffers the results until the batch is done and prints them
+	// ordered by input, cep or duration; empty streams them as they complete
+	sortBy string
+
+	mu      sync.Mutex    // Guards the fields below and writes to stdout/output
+	code    int           // Exit code of the first CEP that failed or was inconsistent
+	pending []batchResult // Results waiting to be printed when sortBy is set
+}
+
+// batchResult is a lookup result along with its position in the input
+type batchResult struct {
+	index int
+	lookupResult
+}
+
+// sortNames lists the values accepted by -sort
+var sortNames = []string{"input", "cep", "duration"}
+
+// run resolves every CEP received from raws and returns the exit code of the
+// batch, exitOK when all of them succeeded. No new lookups are started once
+// ctx is done or the batch timeout expires.
+func (b *batch) run(ctx context.Context, raws <-chan string) int {
+	b.formatter.Begin(os.Stdout)
+	defer b.formatter.End(os.Stdout)
+	defer b.flush()
+
+	parent := ctx
+	var cancel context.CancelFunc
+	if b.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, b.timeout)
+		defer cancel()
+	}
+	if !b.deadline.IsZero() {
+		ctx, cancel = context.WithDeadline(ctx, b.deadline)
+		defer cancel()
+	}
+	defer func() {
+		if parent.Err() == nil && ctx.Err() != nil {
+			deadline, _ := ctx.Deadline()
+			fmt.Fprintf(os.Stderr, "Aviso: prazo do lote esgotado às %s; os CEPs restantes não foram consultados\n", deadline.Format(time.RFC3339))
+		}
+	}()
+
+	sem := make(chan struct{}, b.concurrency)
+	var wg sync.WaitGroup
+
+	index := 0
+	for raw := range raws {
+		start := time.Now()
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		// Checked apart from the select, which picks at random when both
+		// cases are ready
+		if ctx.Err() != nil {
+			wg.Wait()
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return exitTimeout
+			}
+			return exitInterrupted
+		}
+		wg.Add(1)
+		go func(index int, raw string, start time.Time) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			b.resolve(ctx, index, raw, start)
+		}(index, raw, start)
+		index++
+	}
+
+	wg.Wait()
+	return b.code
+}
+
+// resolve looks up a single CEP, the index-th of the input read at start,
+// and formats its result or queues it for flush
+func (b *batch) resolve(ctx context.Context, index int, raw string, start time.Time) {
+	result := resolveCEP(ctx, b.opts, raw)
+	b.opts.notify(result)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	result.elapsed = time.Since(start)
+
+	if b.sortBy == "" {
+		b.formatter.Format(os.Stdout, result)
+	} else {
+		b.pending = append(b.pending, batchResult{index, result})
+	}
+
+	if result.cities != nil {
+		b.fail(exitInconsistent)
+		fmt.Fprintf(os.Stderr, "Aviso: as APIs divergem sobre a cidade do CEP %s: %s\n", result.cep, formatCities(result.cities))
+	}
+
+	if len(result.mismatches) > 0 {
+		b.fail(exitInconsistent)
+	}
+
+	if result.err != nil {
+		b.fail(exitCodeFor(result.err))
+		return
+	}
+	if b.output != nil {
+		if err := appendRecord(b.output, result.winner); err != nil {
+			fmt.Fprintf(os.Stderr, "Erro ao gravar no arquivo de saída: %v\n", err)
+		}
+	}
+}
+
+// flush prints the results buffered for sortBy in the requested order. Ties
+// keep the input order.
+func (b *batch) flush() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sort.SliceStable(b.pending, func(i, j int) bool {
+		return b.pending[i].index < b.pending[j].index
+	})
+	switch b.sortBy {
+	case "cep":
+		sort.SliceStable(b.pending, func(i, j int) bool {
+			return b.pending[i].sortKey() < b.pending[j].sortKey()
+		})
+	case "duration":
+		// Failures carry no duration and go last
+		sort.SliceStable(b.pending, func(i, j int) bool {
+			a, c := b.pending[i], b.pending[j]
+			if (a.err == nil) != (c.err == nil) {
+				return a.err == nil
+			}
+			return a.winner.Duration < c.winner.Duration
+		})
+	}
+	for _, r := range b.pending {
+		b.formatter.Format(os.Stdout, r.lookupResult)
+	}
+	b.pending = nil
+}
+
+// sortKey is the CEP used by -sort cep: the normalized value, or the raw
+// input when it is invalid
+func (r batchResult) sortKey() string {
+	if r.cep != "" {
+		return r.cep
+	}
+	return r.input
+}
+
+// fail records code as the batch exit code unless an earlier CEP already
+// failed. b.mu must be held.
+func (b *batch) fail(code int) {
+	if b.code == exitOK {
+		b.code = code
+	}
+}
+
+// formatCities renders a provider -> city map in a stable order
+func formatCities(cities map[string]string) string {
+	parts := make([]string, 0, len(cities))
+	for api, city := range cities {
+		parts = append(parts, fmt.Sprintf("%s='%s'", api, city))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/prodbygus/golang-multithreading/cep"
+)
+
+// benchStats aggregates the outcome of a provider across benchmark runs
+type benchStats struct {
+	durations []time.Duration // Successful responses only
+	wins      int
+	failures  int
+}
+
+// runBenchmark races the providers n times for the same CEP, without
+// canceling the losers, and prints per-provider latency statistics. The
+// first warmup races are discarded, so DNS lookups and TLS handshakes don't
+// skew the numbers. It stops early when ctx is done and returns false when
+// the CEP is invalid.
+func runBenchmark(ctx context.Context, opts lookupOptions, raw string, n, warmup int) bool {
+	code, err := cep.Normalize(raw)
+	if err != nil {
+		fmt.Printf("%s: %v\n", raw, err)
+		return false
+	}
+	if warmup > 0 {
+		fmt.Printf("Aquecendo as conexões com %d corrida(s) descartada(s)\n", warmup)
+		for i := 0; i < warmup && ctx.Err() == nil; i++ {
+			// Bypass opts.race so warmups stay out of the metrics
+			runCtx, cancel := context.WithTimeout(ctx, opts.client.Timeout())
+			for range opts.client.Race(runCtx, code) {
+			}
+			cancel()
+		}
+	}
+	fmt.Printf("Executando %d corridas para o CEP: %s\n", n, code)
+
+	stats := make(map[string]*benchStats, len(opts.client.Providers()))
+	for _, p := range opts.client.Providers() {
+		stats[p.Name()] = &benchStats{}
+	}
+
+	for i := 0; i < n && ctx.Err() == nil; i++ {
+		runCtx, cancel := context.WithTimeout(ctx, opts.client.Timeout())
+		won := false
+		for r := range opts.race(runCtx, code) {
+			s := stats[r.APIName]
+			if r.Error != nil {
+				s.failures++
+				continue
+			}
+			s.durations = append(s.durations, r.Duration)
+			if !won {
+				s.wins++
+				won = true
+			}
+		}
+		cancel()
+	}
+
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "API\tVitórias\tFalhas\tMín\tMáx\tMédia\tP95")
+	for _, p := range opts.client.Providers() {
+		s := stats[p.Name()]
+		if len(s.durations) == 0 {
+			fmt.Fprintf(w, "%s\t%d\t%d\t-\t-\t-\t-\n", p.Name(), s.wins, s.failures)
+			continue
+		}
+		fastest, slowest, mean := summarize(s.durations)
+		fmt.Fprintf(w, "%s\t%d\t%d\t%.3fs\t%.3fs\t%.3fs\t%.3fs\n", p.Name(), s.wins, s.failures,
+			fastest.Seconds(), slowest.Seconds(), mean.Seconds(), percentile(s.durations, 95).Seconds())
+	}
+	w.Flush()
+	return true
+}
+
+// summarize returns the minimum, maximum and mean of durations, which must
+// not be empty
+func summarize(durations []time.Duration) (fastest, slowest, mean time.Duration) {
+	fastest, slowest = durations[0], durations[0]
+	var total time.Duration
+	for _, d := range durations {
+		if d < fastest {
+			fastest = d
+		}
+		if d > slowest {
+			slowest = d
+		}
+		total += d
+	}
+	return fastest, slowest, total / time.Duration(len(durations))
+}
+
+// percentile returns the p-th percentile of durations using the
+// nearest-rank method. durations must not be empty.
+func percentile(durations []time.Duration, p float64) time.Duration {
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
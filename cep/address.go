@@ -0,0 +1,82 @@
+package cep
+
+import "strings"
+
+// Address is the provider-independent representation of a CEP lookup
+type Address struct {
+	CEP          string `json:"cep"`
+	Street       string `json:"street"`
+	Neighborhood string `json:"neighborhood"`
+	City         string `json:"city"`
+	State        string `json:"state"`
+	IBGE         string `json:"ibge"` // IBGE municipality code, empty when the provider omits it
+	DDD          string `json:"ddd"`  // Telephone area code, empty when the provider omits it
+	// Latitude and Longitude locate the CEP, zero unless the provider sends
+	// them (only BrasilAPI v2 does)
+	Latitude  float64 `json:"latitude,omitempty"`
+	Longitude float64 `json:"longitude,omitempty"`
+	Source    string  `json:"source"`
+	// Service is the backend that resolved the CEP: BrasilAPI reports which
+	// of its sub-services answered, other providers use a fixed name
+	Service string `json:"service"`
+}
+
+// HasCoordinates reports whether the provider located a. (0, 0) lies in the
+// Atlantic, far from any CEP, so it stands for unknown.
+func (a Address) HasCoordinates() bool {
+	return a.Latitude != 0 || a.Longitude != 0
+}
+
+// Complete reports whether a has the street and neighborhood filled in.
+// Some CEPs legitimately lack them, such as those covering a whole town.
+func (a Address) Complete() bool {
+	return strings.TrimSpace(a.Street) != "" && strings.TrimSpace(a.Neighborhood) != ""
+}
+
+// FieldDiff is an Address field whose value differs between two addresses
+type FieldDiff struct {
+	Field string // Address field name, e.g. "City"
+	A, B  string
+}
+
+// DiffAddresses compares the street, neighborhood, city and state of a and b
+// and returns the fields that differ. Values are compared exactly, so
+// whitespace differences are reported too.
+func DiffAddresses(a, b Address) []FieldDiff {
+	fields := []FieldDiff{
+		{"Street", a.Street, b.Street},
+		{"Neighborhood", a.Neighborhood, b.Neighborhood},
+		{"City", a.City, b.City},
+		{"State", a.State, b.State},
+	}
+
+	var diffs []FieldDiff
+	for _, f := range fields {
+		if f.A != f.B {
+			diffs = append(diffs, f)
+		}
+	}
+	return diffs
+}
+
+// accentFolder strips the diacritics used in Brazilian place names
+var accentFolder = strings.NewReplacer(
+	"á", "a", "à", "a", "â", "a", "ã", "a", "ä", "a",
+	"é", "e", "ê", "e", "è", "e", "ë", "e",
+	"í", "i", "î", "i", "ì", "i", "ï", "i",
+	"ó", "o", "ô", "o", "õ", "o", "ò", "o", "ö", "o",
+	"ú", "u", "û", "u", "ù", "u", "ü", "u",
+	"ç", "c", "ñ", "n",
+)
+
+// SameCity reports whether a and b name the same municipality, ignoring
+// case, surrounding whitespace and accents (providers disagree on those
+// without meaning a different city)
+func SameCity(a, b string) bool {
+	return fold(a) == fold(b)
+}
+
+// fold lowercases s, trims it and strips its accents for loose comparisons
+func fold(s string) string {
+	return accentFolder.Replace(strings.ToLower(strings.TrimSpace(s)))
+}
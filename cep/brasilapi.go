@@ -0,0 +1,138 @@
+package cep
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// BrasilAPICEP represents the structure returned by BrasilAPI
+type BrasilAPICEP struct {
+	Cep          string `json:"cep"`
+	State        string `json:"state"`
+	City         string `json:"city"`
+	Neighborhood string `json:"neighborhood"`
+	Street       string `json:"street"`
+	Service      string `json:"service"`
+	// Location is only sent by v2; its coordinates are empty when unknown
+	Location struct {
+		Coordinates struct {
+			Latitude  string `json:"latitude"`
+			Longitude string `json:"longitude"`
+		} `json:"coordinates"`
+	} `json:"location"`
+}
+
+// BrasilAPIProvider fetches CEP data from BrasilAPI
+type BrasilAPIProvider struct {
+	Client  *http.Client
+	BaseURL string // Defaults to the endpoint of Version when empty
+	Version int    // 1 (the default) or 2, which adds the coordinates
+}
+
+// BrasilAPIBaseURL is the public v1 endpoint used when BaseURL is empty
+const BrasilAPIBaseURL = "https://brasilapi.com.br/api/cep/v1"
+
+// BrasilAPIV2BaseURL is the public v2 endpoint, whose answers include the
+// coordinates of the CEP
+const BrasilAPIV2BaseURL = "https://brasilapi.com.br/api/cep/v2"
+
+func (p BrasilAPIProvider) baseURL() string {
+	switch {
+	case p.BaseURL != "":
+		return p.BaseURL
+	case p.Version == 2:
+		return BrasilAPIV2BaseURL
+	}
+	return BrasilAPIBaseURL
+}
+
+// Name returns the provider name used in the output
+func (BrasilAPIProvider) Name() string { return "BrasilAPI" }
+
+// URL returns the address queried for cep
+func (p BrasilAPIProvider) URL(cep string) string {
+	return fmt.Sprintf("%s/%s", p.baseURL(), cep)
+}
+
+// Fetch queries BrasilAPI for the given CEP
+func (p BrasilAPIProvider) Fetch(ctx context.Context, cep string) (Response, error) {
+	url := p.URL(cep)
+
+	var data BrasilAPICEP
+	if err := getJSON(ctx, p.Client, p.Name(), url, schemaFor(p.Name()), &data); err != nil {
+		return Response{APIName: p.Name()}, withBrasilAPIMessage(err)
+	}
+	return Response{APIName: p.Name(), Address: fromBrasilAPI(data)}, nil
+}
+
+// fromBrasilAPI maps a BrasilAPICEP response into an Address
+func fromBrasilAPI(data BrasilAPICEP) Address {
+	addr := Address{
+		CEP:          data.Cep,
+		Street:       data.Street,
+		Neighborhood: data.Neighborhood,
+		City:         data.City,
+		State:        data.State,
+		Source:       "BrasilAPI",
+		Service:      data.Service,
+	}
+	// v2 sends the coordinates as strings, empty for CEPs it can't locate
+	coords := data.Location.Coordinates
+	lat, latErr := strconv.ParseFloat(coords.Latitude, 64)
+	lng, lngErr := strconv.ParseFloat(coords.Longitude, 64)
+	if latErr == nil && lngErr == nil {
+		addr.Latitude, addr.Longitude = lat, lng
+	}
+	return addr
+}
+
+// brasilAPIError is the body BrasilAPI sends along with error statuses
+type brasilAPIError struct {
+	Name    string `json:"name"`
+	Message string `json:"message"`
+	Type    string `json:"type"`
+	Errors  []struct {
+		Name    string `json:"name"`
+		Message string `json:"message"`
+		Service string `json:"service"`
+	} `json:"errors"`
+}
+
+// withBrasilAPIMessage fills the Message of a status error from BrasilAPI's
+// error body, including what each of its upstream services reported. The
+// error is left as is when the body isn't in the expected shape.
+func withBrasilAPIMessage(err error) error {
+	var body *[]byte
+	var message *string
+	var notFoundErr *NotFoundError
+	var statusErr *HTTPStatusError
+	switch {
+	case errors.As(err, &notFoundErr):
+		body, message = &notFoundErr.Body, &notFoundErr.Message
+	case errors.As(err, &statusErr):
+		body, message = &statusErr.Body, &statusErr.Message
+	default:
+		return err
+	}
+
+	var data brasilAPIError
+	if json.Unmarshal(*body, &data) != nil || data.Message == "" {
+		return err
+	}
+	details := make([]string, 0, len(data.Errors))
+	for _, e := range data.Errors {
+		if e.Message != "" {
+			details = append(details, fmt.Sprintf("%s: %s", e.Service, e.Message))
+		}
+	}
+	*message = data.Message
+	if len(details) > 0 {
+		*message += " (" + strings.Join(details, "; ") + ")"
+	}
+	return err
+}
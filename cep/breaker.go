@@ -0,0 +1,129 @@
+package cep
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned by a Breaker that is skipping its provider
+var ErrCircuitOpen = errors.New("circuito aberto: API ignorada após falhas consecutivas")
+
+// Circuit states reported by BreakerState
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half-open"
+)
+
+// Breaker wraps a provider with a circuit breaker. After Threshold
+// consecutive failures the circuit opens and Fetch fails fast with
+// ErrCircuitOpen for Cooldown; then a single probe is let through
+// (half-open), which closes the circuit on success or opens it again on
+// failure. A CEP not found or a canceled request isn't a failure. It is safe
+// for concurrent use.
+type Breaker struct {
+	provider  CEPProvider
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time // time.Now, replaced in tests
+
+	mu       sync.Mutex
+	failures int       // Consecutive failures
+	openedAt time.Time // Zero while closed
+	probing  bool      // A half-open probe is in flight
+}
+
+// NewBreaker wraps p in a Breaker that opens after threshold consecutive
+// failures and stays open for cooldown
+func NewBreaker(p CEPProvider, threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{provider: p, threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+// Name returns the wrapped provider's name
+func (b *Breaker) Name() string { return b.provider.Name() }
+
+// URL returns the wrapped provider's URL for cep, if it exposes one
+func (b *Breaker) URL(cep string) string {
+	if up, ok := b.provider.(URLProvider); ok {
+		return up.URL(cep)
+	}
+	return ""
+}
+
+// Unwrap returns the wrapped provider
+func (b *Breaker) Unwrap() CEPProvider { return b.provider }
+
+// Fetch queries the wrapped provider unless the circuit is open
+func (b *Breaker) Fetch(ctx context.Context, cep string) (Response, error) {
+	probe, ok := b.allow()
+	if !ok {
+		return Response{APIName: b.Name()}, ErrCircuitOpen
+	}
+
+	result, err := b.provider.Fetch(ctx, cep)
+	b.record(err, probe)
+	return result, err
+}
+
+// allow reports whether a request may go through and whether it is the
+// half-open probe
+func (b *Breaker) allow() (probe, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.openedAt.IsZero() {
+		return false, true
+	}
+	if b.probing || b.now().Sub(b.openedAt) < b.cooldown {
+		return false, false
+	}
+	b.probing = true
+	return true, true
+}
+
+// record updates the circuit with the outcome of a request
+func (b *Breaker) record(err error, probe bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if probe {
+		b.probing = false
+	}
+	switch {
+	case err == nil, errors.Is(err, ErrCEPNotFound):
+		b.failures = 0
+		b.openedAt = time.Time{}
+	case errors.Is(err, context.Canceled):
+		// Lost the race; says nothing about the provider's health
+	default:
+		b.failures++
+		if probe || b.failures >= b.threshold {
+			b.openedAt = b.now()
+			logf(context.Background(), b.Name(), "circuito aberto após %d falha(s) consecutiva(s)", b.failures)
+		}
+	}
+}
+
+// BreakerState is a snapshot of a Breaker
+type BreakerState struct {
+	State    string `json:"state"`
+	Failures int    `json:"consecutive_failures"`
+}
+
+// State returns the current state of the circuit
+func (b *Breaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state := CircuitClosed
+	switch {
+	case b.openedAt.IsZero():
+	case b.probing || b.now().Sub(b.openedAt) >= b.cooldown:
+		state = CircuitHalfOpen
+	default:
+		state = CircuitOpen
+	}
+	return BreakerState{State: state, Failures: b.failures}
+}
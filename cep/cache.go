@@ -0,0 +1,270 @@
+package cep
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheSource is the APIName of responses served from a Cache
+const CacheSource = "cache"
+
+// cacheEntry is a cached response and the moment it stops being valid
+type cacheEntry struct {
+	response  Response
+	expiresAt time.Time
+}
+
+// validated is a provider's response body kept with its validators, so the
+// next request for the same URL can be conditional
+type validated struct {
+	etag         string
+	lastModified string
+	body         []byte
+	expiresAt    time.Time // Set by keep
+}
+
+// diskEntry is the JSON file a disk-backed Cache keeps for each CEP
+type diskEntry struct {
+	FetchedAt time.Time `json:"fetched_at"`
+	Address   Address   `json:"address"`
+}
+
+// Cache stores winning responses keyed by normalized CEP for a fixed TTL.
+// With WithCache it also keeps the validated bodies of provider responses,
+// keyed by URL, for twice the TTL: a body outlives the entry it backs by one
+// TTL, during which the next request for it can be conditional. Expired
+// entries and bodies are swept about once per TTL, so a long-running server
+// only holds what was looked up recently. It is safe for concurrent use.
+type Cache struct {
+	mu      sync.RWMutex
+	ttl     time.Duration
+	dir     string // Where responses are persisted, empty for memory only
+	entries map[string]cacheEntry
+	bodies  map[string]validated
+	sweptAt time.Time // Last sweep of expired entries and bodies
+}
+
+// NewCache returns an empty Cache whose entries expire after ttl
+func NewCache(ttl time.Duration) *Cache {
+	return &Cache{ttl: ttl, entries: make(map[string]cacheEntry), bodies: make(map[string]validated), sweptAt: time.Now()}
+}
+
+// NewDiskCache returns a Cache that also persists responses in dir, one
+// <cep>.json file each, so they survive the process. Entries written by
+// earlier runs expire ttl after they were fetched. dir is created if needed.
+func NewDiskCache(dir string, ttl time.Duration) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	c := NewCache(ttl)
+	c.dir = dir
+	return c, nil
+}
+
+// Get returns the cached response for cep, if present and not expired. The
+// returned response has APIName set to CacheSource, while Address.Source
+// keeps the provider that originally resolved it.
+func (c *Cache) Get(cep string) (Response, bool) {
+	c.mu.RLock()
+	entry, ok := c.entries[cep]
+	c.mu.RUnlock()
+
+	if !ok && c.dir != "" {
+		entry, ok = c.load(cep)
+	}
+	if !ok || time.Now().After(entry.expiresAt) {
+		return Response{}, false
+	}
+
+	resp := entry.response
+	resp.APIName = CacheSource
+	resp.Duration = 0
+	return resp, true
+}
+
+// Set stores a successful response for cep
+func (c *Cache) Set(cep string, resp Response) {
+	if resp.Error != nil {
+		return
+	}
+
+	now := time.Now()
+	c.mu.Lock()
+	c.entries[cep] = cacheEntry{response: resp, expiresAt: now.Add(c.ttl)}
+	c.sweep(now)
+	c.mu.Unlock()
+
+	if c.dir != "" {
+		if err := c.store(cep, diskEntry{FetchedAt: now, Address: resp.Address}); err != nil {
+			warn("falha ao gravar o cache em disco", "cep", cep, "error", err)
+		}
+	}
+}
+
+// Clear removes every entry and returns how many there were, expired or not,
+// counting each CEP once whether it was in memory, on disk or both. The
+// validated bodies and the temporary files of interrupted writes go too, but
+// aren't counted. Other files in the directory are left alone.
+func (c *Cache) Clear() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	removed := make(map[string]bool, len(c.entries))
+	for cep := range c.entries {
+		removed[cep] = true
+	}
+	c.entries = make(map[string]cacheEntry)
+	c.bodies = make(map[string]validated)
+
+	if c.dir != "" {
+		// Only the files path and store create: the directory may hold others
+		name := filepath.Join(c.dir, strings.Repeat("[0-9]", 8)+".json")
+		files, _ := filepath.Glob(name)
+		for _, f := range files {
+			if os.Remove(f) == nil {
+				removed[strings.TrimSuffix(filepath.Base(f), ".json")] = true
+			}
+		}
+		leftovers, _ := filepath.Glob(name + ".*.tmp")
+		for _, f := range leftovers {
+			os.Remove(f)
+		}
+	}
+	return len(removed)
+}
+
+// path returns the file of cep in c.dir, or "" when cep isn't 8 digits,
+// which never happens for a normalized CEP
+func (c *Cache) path(cep string) string {
+	if len(cep) != 8 || strings.Trim(cep, "0123456789") != "" {
+		return ""
+	}
+	return filepath.Join(c.dir, cep+".json")
+}
+
+// load reads the entry of cep from disk and keeps it in memory. A missing or
+// unreadable file is a miss.
+func (c *Cache) load(cep string) (cacheEntry, bool) {
+	path := c.path(cep)
+	if path == "" {
+		return cacheEntry{}, false
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			warn("falha ao ler o cache em disco", "cep", cep, "error", err)
+		}
+		return cacheEntry{}, false
+	}
+	var d diskEntry
+	if err := json.Unmarshal(b, &d); err != nil {
+		warn("arquivo de cache inválido, ignorado", "path", path, "error", err)
+		return cacheEntry{}, false
+	}
+
+	entry := cacheEntry{
+		response:  Response{Address: d.Address, APIName: d.Address.Source},
+		expiresAt: d.FetchedAt.Add(c.ttl),
+	}
+	c.mu.Lock()
+	c.entries[cep] = entry
+	c.mu.Unlock()
+	return entry, true
+}
+
+// warn logs a disk cache failure to Logger, if set. The cache then behaves
+// as if the entry wasn't there, so the failure costs only a lookup.
+func warn(msg string, args ...interface{}) {
+	if Logger != nil {
+		Logger.Warn(msg, args...)
+	}
+}
+
+// store writes d as the file of cep. It writes a temporary file in the same
+// directory and renames it over the old one, so concurrent writers, in this
+// process or another, never leave a partial file behind: readers see either
+// the previous entry or the new one.
+func (c *Cache) store(cep string, d diskEntry) error {
+	path := c.path(cep)
+	if path == "" {
+		return nil
+	}
+	b, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(c.dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// cacheKey is the context key of the Cache set by WithCache
+type cacheKey struct{}
+
+// requestCache returns the Cache revalidating the requests made with ctx
+func requestCache(ctx context.Context) *Cache {
+	c, _ := ctx.Value(cacheKey{}).(*Cache)
+	return c
+}
+
+// validated returns the body kept for url, if any and not expired
+func (c *Cache) validated(url string) (validated, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	v, ok := c.bodies[url]
+	if !ok || time.Now().After(v.expiresAt) {
+		return validated{}, false
+	}
+	return v, true
+}
+
+// keep stores the body of url's response with its validators, valid for two
+// TTLs from now. Keeping a body again, as after a 304, renews it.
+func (c *Cache) keep(url string, v validated) {
+	now := time.Now()
+	v.expiresAt = now.Add(2 * c.ttl)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.bodies[url] = v
+	c.sweep(now)
+}
+
+// sweep drops the expired entries and bodies if the last sweep was at least
+// a TTL ago, which keeps its cost spread over many writes. c.mu must be held.
+func (c *Cache) sweep(now time.Time) {
+	if now.Sub(c.sweptAt) < c.ttl {
+		return
+	}
+	c.sweptAt = now
+	for cep, entry := range c.entries {
+		if now.After(entry.expiresAt) {
+			delete(c.entries, cep)
+		}
+	}
+	for url, v := range c.bodies {
+		if now.After(v.expiresAt) {
+			delete(c.bodies, url)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+// Package cep races several Brazilian postal code (CEP) APIs and returns the
+// fastest answer.
+package cep
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Response represents a generic API response with the API source
+type Response struct {
+	Address  Address
+	APIName  string
+	Error    error
+	Duration time.Duration // Add duration field to track response time
+}
+
+// Normalize strips every non-digit from raw and validates that the result
+// has 8 digits. A 7-digit value is left-padded with a zero, since CEPs starting
+// with 0 often lose it when stored as numbers (e.g. 1153000 for 01153000).
+func Normalize(raw string) (string, error) {
+	var b strings.Builder
+	for _, r := range raw {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+
+	cep := b.String()
+	if len(cep) == 7 {
+		cep = "0" + cep
+	}
+	if len(cep) != 8 {
+		return "", ErrInvalidCEP
+	}
+	return cep, nil
+}
+
+// ProviderTimeout, when positive, bounds each provider's request on its own.
+// Every provider gets a child of the race context, so a slow one is cut off
+// without affecting the others.
+var ProviderTimeout time.Duration
+
+// IncompleteRetries is the number of extra attempts made when a provider
+// answers successfully but without street or neighborhood, which some
+// providers fix on a second hit. The last answer is accepted as is.
+var IncompleteRetries = 0
+
+// Race starts one goroutine per provider and returns a channel that receives
+// every provider's Response as it completes. The channel is closed once all
+// providers have reported. It is buffered for every provider, so none of the
+// goroutines blocks when the caller stops reading after the winner.
+func Race(ctx context.Context, cep string, providers []CEPProvider) <-chan Response {
+	results := make(chan Response, len(providers))
+
+	// Wait group to close the channel once all API calls complete
+	var wg sync.WaitGroup
+	wg.Add(len(providers))
+
+	for _, p := range providers {
+		go func(p CEPProvider) {
+			defer wg.Done()
+			results <- fetch(ctx, p, cep)
+		}(p)
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	return results
+}
+
+// Fallback tries the providers one at a time, in order, stopping at the
+// first success. Like Race, it returns a channel that receives the Response
+// of every provider tried and is closed once the chain is over, so it can be
+// read with Fastest. ctx bounds the whole chain, not each attempt.
+func Fallback(ctx context.Context, cep string, providers []CEPProvider) <-chan Response {
+	results := make(chan Response, len(providers))
+
+	go func() {
+		defer close(results)
+		for _, p := range providers {
+			if ctx.Err() != nil {
+				return
+			}
+			result := fetch(ctx, p, cep)
+			results <- result
+			if result.Error == nil {
+				return
+			}
+		}
+	}()
+
+	return results
+}
+
+// fetch queries a single provider and fills in the Response bookkeeping
+// fields, honoring ProviderTimeout or the provider's Tuned timeout
+func fetch(ctx context.Context, p CEPProvider, cep string) Response {
+	startTime := time.Now()
+
+	if timeout := providerTimeout(p); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	result, err := p.Fetch(ctx, cep)
+	for attempt := 0; err == nil && !result.Address.Complete() && attempt < IncompleteRetries; attempt++ {
+		logf(ctx, p.Name(), "resposta incompleta (sem rua ou bairro), nova tentativa em %s", RetryBackoff)
+		if !sleep(ctx, RetryBackoff) {
+			break
+		}
+		result, err = p.Fetch(ctx, cep)
+	}
+	if err == nil {
+		err = checkCEP(p.Name(), cep, result.Address.CEP)
+	}
+	result.APIName = p.Name()
+	result.Duration = time.Since(startTime)
+	result.Error = err
+	return result
+}
+
+// FirstN reads results until n providers have answered successfully and
+// returns their responses in arrival order. If the providers finish or ctx
+// is done first, it returns the answers received so far; with none, it
+// fails like Fastest.
+func FirstN(ctx context.Context, results <-chan Response, n int) ([]Response, error) {
+	var answers, failures []Response
+	for {
+		select {
+		case result, ok := <-results:
+			if !ok {
+				if len(answers) > 0 {
+					return answers, nil
+				}
+				if err := ctx.Err(); err != nil {
+					return nil, err
+				}
+				return nil, &AllFailedError{Failures: failures}
+			}
+			if result.Error != nil {
+				failures = append(failures, result)
+				continue
+			}
+			answers = append(answers, result)
+			if len(answers) >= n {
+				return answers, nil
+			}
+		case <-ctx.Done():
+			if len(answers) > 0 {
+				return answers, nil
+			}
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Fastest reads results until the first successful Response. If every
+// provider fails it returns an *AllFailedError, or ctx.Err() when the
+// failures were caused by the context expiring.
+func Fastest(ctx context.Context, results <-chan Response) (Response, error) {
+	var failures []Response
+	for {
+		select {
+		case result, ok := <-results:
+			if !ok {
+				if err := ctx.Err(); err != nil {
+					return Response{}, err
+				}
+				return Response{}, &AllFailedError{Failures: failures}
+			}
+			if result.Error != nil {
+				failures = append(failures, result)
+				continue
+			}
+			return result, nil
+		case <-ctx.Done():
+			return Response{}, ctx.Err()
+		}
+	}
+}
+
+// FetchFastest races the default providers and returns the first successful
+// response, along with the time it took. The remaining providers are
+// canceled once a winner is known. Use New for more control.
+func FetchFastest(ctx context.Context, cep string) (Response, error) {
+	return New().Lookup(ctx, cep)
+}
+
+// FetchAll queries the default providers and returns every response, in
+// the order they arrived, failures included. See Client.FetchAll.
+func FetchAll(ctx context.Context, cep string) ([]Response, error) {
+	return New().FetchAll(ctx, cep)
+}
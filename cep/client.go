@@ -0,0 +1,136 @@
+package cep
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Client races a set of providers with its own configuration, for programs
+// that embed the lookup. Build one with New; the zero value is not usable.
+type Client struct {
+	providers  []CEPProvider
+	httpClient *http.Client
+	timeout    time.Duration
+	fallback   bool
+	cache      *Cache // Revalidates provider responses, nil without WithCache
+}
+
+// Option configures a Client built by New
+type Option func(*Client)
+
+// WithProviders sets the providers raced by the Client, replacing the
+// default BrasilAPI, ViaCEP, Postmon and OpenCEP
+func WithProviders(providers ...CEPProvider) Option {
+	return func(c *Client) { c.providers = providers }
+}
+
+// WithHTTPClient sets the HTTP client used by the default providers. It has
+// no effect on providers passed to WithProviders.
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *Client) { c.httpClient = client }
+}
+
+// WithTimeout bounds every Lookup. Zero, the default, relies on the caller's
+// context alone.
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) { c.timeout = d }
+}
+
+// WithFallback makes the Client try its providers one at a time, in order,
+// instead of racing them. See Fallback.
+func WithFallback() Option {
+	return func(c *Client) { c.fallback = true }
+}
+
+// WithCache makes the Client's HTTP providers keep the body and validators
+// (ETag or Last-Modified) of each response in cache, and send them back in
+// a conditional request next time, reusing the kept body on a 304. Providers
+// that send no validators are requested as usual.
+func WithCache(cache *Cache) Option {
+	return func(c *Client) { c.cache = cache }
+}
+
+// New returns a Client configured by opts
+func New(opts ...Option) *Client {
+	c := &Client{}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.providers == nil {
+		c.providers = DefaultProviders(c.httpClient)
+	}
+	return c
+}
+
+// Providers returns the providers used by c
+func (c *Client) Providers() []CEPProvider { return c.providers }
+
+// Timeout returns the timeout applied to every Lookup, zero if none
+func (c *Client) Timeout() time.Duration { return c.timeout }
+
+// Race starts a lookup of cep on every provider, or on each in turn when
+// the Client was built WithFallback, without applying the timeout. The
+// channel behaves like the one returned by the package-level Race.
+func (c *Client) Race(ctx context.Context, cep string) <-chan Response {
+	if c.cache != nil {
+		ctx = context.WithValue(ctx, cacheKey{}, c.cache)
+	}
+	if c.fallback {
+		return Fallback(ctx, cep, c.providers)
+	}
+	return Race(ctx, cep, c.providers)
+}
+
+// Lookup normalizes raw and returns the first successful response within
+// the timeout. The remaining providers are canceled once a winner is known.
+func (c *Client) Lookup(ctx context.Context, raw string) (Response, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	ctx, cancelRace := context.WithCancel(ctx)
+	defer cancelRace()
+
+	results, err := c.start(ctx, raw)
+	if err != nil {
+		return Response{}, err
+	}
+	return Fastest(ctx, results)
+}
+
+// FetchAll normalizes raw and waits, within the timeout, for every provider
+// to answer, so callers can pick or reconcile the answers themselves. The
+// responses come in the order they arrived, failures included with their
+// Error set; with WithFallback only the providers tried are there. err is
+// non-nil only for an invalid CEP.
+func (c *Client) FetchAll(ctx context.Context, raw string) ([]Response, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	results, err := c.start(ctx, raw)
+	if err != nil {
+		return nil, err
+	}
+	var all []Response
+	for r := range results {
+		all = append(all, r)
+	}
+	return all, nil
+}
+
+// start normalizes raw and starts its lookup, the common ground of Lookup
+// and FetchAll
+func (c *Client) start(ctx context.Context, raw string) (<-chan Response, error) {
+	code, err := Normalize(raw)
+	if err != nil {
+		return nil, err
+	}
+	return c.Race(ctx, code), nil
+}
+
+// withTimeout bounds ctx by the Client's timeout, if it has one
+func (c *Client) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.timeout > 0 {
+		return context.WithTimeout(ctx, c.timeout)
+	}
+	return context.WithCancel(ctx)
+}
@@ -0,0 +1,193 @@
+package cep
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrInvalidCEP is returned when a CEP doesn't have 8 digits
+var ErrInvalidCEP = errors.New("CEP inválido: deve conter 8 dígitos")
+
+// ErrCEPNotFound is returned when a provider doesn't know the requested CEP
+var ErrCEPNotFound = errors.New("CEP não encontrado")
+
+// NotFoundError reports that a provider doesn't know the requested CEP. It
+// matches ErrCEPNotFound with errors.Is.
+type NotFoundError struct {
+	Provider string
+	Message  string // Explanation from the provider's error body, if any
+	Body     []byte // Start of the response body, nil when the answer was a 200
+}
+
+func (e *NotFoundError) Error() string {
+	if e.Message != "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%v na %s", ErrCEPNotFound, e.Provider)
+}
+
+// Is makes errors.Is(err, ErrCEPNotFound) hold for every *NotFoundError
+func (e *NotFoundError) Is(target error) bool {
+	return target == ErrCEPNotFound
+}
+
+// HTTPStatusError is returned when a provider answers with an unexpected
+// HTTP status code
+type HTTPStatusError struct {
+	Provider string
+	Code     int
+	Message  string // Explanation from the provider's error body, if any
+	Body     []byte // Start of the response body
+
+	// RetryAfter is the wait asked for by the Retry-After header, zero
+	// when the provider sent none
+	RetryAfter time.Duration
+}
+
+func (e *HTTPStatusError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("%s (status code: %d)", e.Message, e.Code)
+	}
+	return fmt.Sprintf("status code: %d", e.Code)
+}
+
+// Temporary reports whether the status is a server-side failure (5xx) or
+// throttling (429) that may succeed on a later attempt
+func (e *HTTPStatusError) Temporary() bool {
+	return e.Code >= 500 || e.Code == http.StatusTooManyRequests
+}
+
+// NetworkError wraps a failure to reach a provider or read its response,
+// such as a refused connection or an expired context
+type NetworkError struct {
+	Provider string
+	Err      error
+}
+
+func (e *NetworkError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *NetworkError) Unwrap() error { return e.Err }
+
+// Temporary reports whether retrying may help; context cancellation and
+// deadlines are final
+func (e *NetworkError) Temporary() bool {
+	return !errors.Is(e.Err, context.Canceled) && !errors.Is(e.Err, context.DeadlineExceeded)
+}
+
+// DecodeError is returned when a provider's response body isn't the JSON
+// document we expect
+type DecodeError struct {
+	Provider string
+	Err      error
+	Snippet  string // Start of the raw body, to tell e.g. an HTML error page
+}
+
+func (e *DecodeError) Error() string {
+	if e.Snippet == "" {
+		return fmt.Sprintf("%s: erro ao decodificar resposta: %v", e.Provider, e.Err)
+	}
+	return fmt.Sprintf("%s: erro ao decodificar resposta: %v (início da resposta: %q)", e.Provider, e.Err, e.Snippet)
+}
+
+func (e *DecodeError) Unwrap() error { return e.Err }
+
+// Temporary reports whether the body looks cut short by the network rather
+// than malformed; malformed JSON or an oversized body won't improve on a
+// retry
+func (e *DecodeError) Temporary() bool {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	return !errors.As(e.Err, &syntaxErr) && !errors.As(e.Err, &typeErr) && !errors.Is(e.Err, ErrResponseTooLarge)
+}
+
+// ErrCEPMismatch is matched by the *MismatchError of a provider answering
+// with another CEP's address
+var ErrCEPMismatch = errors.New("resposta para CEP diferente do solicitado")
+
+// MismatchError is returned when a provider's address is for a CEP other
+// than the requested one, as when a misbehaving proxy cache mixes up
+// responses. It matches ErrCEPMismatch with errors.Is.
+type MismatchError struct {
+	Provider  string
+	Requested string
+	Got       string
+}
+
+func (e *MismatchError) Error() string {
+	return fmt.Sprintf("%v: pedido %s, recebido %s", ErrCEPMismatch, e.Requested, e.Got)
+}
+
+// Is makes errors.Is(err, ErrCEPMismatch) hold for every *MismatchError
+func (e *MismatchError) Is(target error) bool {
+	return target == ErrCEPMismatch
+}
+
+// checkCEP returns a *MismatchError when got, the CEP in provider's answer,
+// isn't requested once both are normalized. An answer without a CEP is let
+// through, since not every provider echoes it.
+func checkCEP(provider, requested, got string) error {
+	if got == "" {
+		return nil
+	}
+	want, err := Normalize(requested)
+	if err != nil {
+		return nil
+	}
+	if have, err := Normalize(got); err != nil || have != want {
+		return &MismatchError{Provider: provider, Requested: want, Got: got}
+	}
+	return nil
+}
+
+// ErrResponseTooLarge is wrapped by the *DecodeError of a response body
+// longer than MaxResponseSize
+var ErrResponseTooLarge = errors.New("resposta maior que o limite permitido")
+
+// temporary reports whether err is a transient failure worth retrying
+func temporary(err error) bool {
+	var t interface{ Temporary() bool }
+	return errors.As(err, &t) && t.Temporary()
+}
+
+// AllFailedError is returned when every provider failed to resolve a CEP
+type AllFailedError struct {
+	Failures []Response
+}
+
+func (e *AllFailedError) Error() string {
+	msgs := make([]string, len(e.Failures))
+	for i, f := range e.Failures {
+		msgs[i] = fmt.Sprintf("%s: %v", f.APIName, f.Error)
+	}
+	return "todas as APIs falharam: " + strings.Join(msgs, "; ")
+}
+
+// Unwrap exposes each provider's error, so errors.Is and errors.As can look
+// through an *AllFailedError
+func (e *AllFailedError) Unwrap() []error {
+	errs := make([]error, len(e.Failures))
+	for i, f := range e.Failures {
+		errs[i] = f.Error
+	}
+	return errs
+}
+
+// SchemaError is returned when a provider's response doesn't match its
+// embedded JSON Schema, which usually means the API changed its format
+type SchemaError struct {
+	Provider string
+	Err      error
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("resposta inesperada da %s: %v", e.Provider, e.Err)
+}
+
+func (e *SchemaError) Unwrap() error { return e.Err }
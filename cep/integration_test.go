@@ -0,0 +1,41 @@
+//go:build integration
+
+package cep
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// stableCEP is the São Paulo central post office, unlikely to ever change
+const stableCEP = "01001000"
+
+// TestRealProviders queries the public endpoints to catch changes in their
+// response shape. Run with: go test -tags integration ./cep
+func TestRealProviders(t *testing.T) {
+	for _, p := range []CEPProvider{BrasilAPIProvider{}, ViaCEPProvider{}} {
+		p := p
+		t.Run(p.Name(), func(t *testing.T) {
+			t.Parallel()
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			resp, err := p.Fetch(ctx, stableCEP)
+			if err != nil {
+				t.Fatalf("Fetch(%s): %v", stableCEP, err)
+			}
+			addr := resp.Address
+			if addr.City == "" || addr.State == "" {
+				t.Fatalf("Fetch(%s) = %+v, want non-empty city and state", stableCEP, addr)
+			}
+			if addr.State != "SP" {
+				t.Errorf("state = %q, want SP", addr.State)
+			}
+			// ViaCEP formats the CEP with a hyphen
+			if got, _ := Normalize(addr.CEP); got != stableCEP {
+				t.Errorf("CEP = %q, want %s", addr.CEP, stableCEP)
+			}
+		})
+	}
+}
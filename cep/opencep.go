@@ -0,0 +1,67 @@
+package cep
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// OpenCEP represents the structure returned by OpenCEP API
+type OpenCEP struct {
+	Cep        string `json:"cep"`
+	Logradouro string `json:"logradouro"`
+	Bairro     string `json:"bairro"`
+	Localidade string `json:"localidade"`
+	Uf         string `json:"uf"`
+	Ibge       string `json:"ibge"`
+}
+
+// OpenCEPProvider fetches CEP data from OpenCEP API, which can also be
+// self-hosted
+type OpenCEPProvider struct {
+	Client  *http.Client
+	BaseURL string // Defaults to OpenCEPBaseURL when empty
+}
+
+// OpenCEPBaseURL is the public endpoint used when BaseURL is empty
+const OpenCEPBaseURL = "https://opencep.com/v1"
+
+func (p OpenCEPProvider) baseURL() string {
+	if p.BaseURL != "" {
+		return p.BaseURL
+	}
+	return OpenCEPBaseURL
+}
+
+// Name returns the provider name used in the output
+func (OpenCEPProvider) Name() string { return "OpenCEP" }
+
+// URL returns the address queried for cep
+func (p OpenCEPProvider) URL(cep string) string {
+	return fmt.Sprintf("%s/%s", p.baseURL(), cep)
+}
+
+// Fetch queries OpenCEP for the given CEP
+func (p OpenCEPProvider) Fetch(ctx context.Context, cep string) (Response, error) {
+	url := p.URL(cep)
+
+	var data OpenCEP
+	if err := getJSON(ctx, p.Client, p.Name(), url, schemaFor(p.Name()), &data); err != nil {
+		return Response{APIName: p.Name()}, err
+	}
+	return Response{APIName: p.Name(), Address: fromOpenCEP(data)}, nil
+}
+
+// fromOpenCEP maps an OpenCEP response into an Address
+func fromOpenCEP(data OpenCEP) Address {
+	return Address{
+		CEP:          data.Cep,
+		Street:       data.Logradouro,
+		Neighborhood: data.Bairro,
+		City:         data.Localidade,
+		State:        data.Uf,
+		IBGE:         data.Ibge,
+		Source:       "OpenCEP",
+		Service:      "opencep",
+	}
+}
@@ -0,0 +1,68 @@
+package cep
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// Postmon represents the structure returned by Postmon API
+type Postmon struct {
+	Cep        string `json:"cep"`
+	Estado     string `json:"estado"`
+	Cidade     string `json:"cidade"`
+	Bairro     string `json:"bairro"`
+	Logradouro string `json:"logradouro"`
+	CidadeInfo struct {
+		CodigoIBGE string `json:"codigo_ibge"`
+	} `json:"cidade_info"`
+}
+
+// PostmonProvider fetches CEP data from Postmon API
+type PostmonProvider struct {
+	Client  *http.Client
+	BaseURL string // Defaults to PostmonBaseURL when empty
+}
+
+// PostmonBaseURL is the public endpoint used when BaseURL is empty
+const PostmonBaseURL = "https://api.postmon.com.br/v1/cep"
+
+func (p PostmonProvider) baseURL() string {
+	if p.BaseURL != "" {
+		return p.BaseURL
+	}
+	return PostmonBaseURL
+}
+
+// Name returns the provider name used in the output
+func (PostmonProvider) Name() string { return "Postmon" }
+
+// URL returns the address queried for cep
+func (p PostmonProvider) URL(cep string) string {
+	return fmt.Sprintf("%s/%s", p.baseURL(), cep)
+}
+
+// Fetch queries Postmon for the given CEP
+func (p PostmonProvider) Fetch(ctx context.Context, cep string) (Response, error) {
+	url := p.URL(cep)
+
+	var data Postmon
+	if err := getJSON(ctx, p.Client, p.Name(), url, schemaFor(p.Name()), &data); err != nil {
+		return Response{APIName: p.Name()}, err
+	}
+	return Response{APIName: p.Name(), Address: fromPostmon(data)}, nil
+}
+
+// fromPostmon maps a Postmon response into an Address
+func fromPostmon(data Postmon) Address {
+	return Address{
+		CEP:          data.Cep,
+		Street:       data.Logradouro,
+		Neighborhood: data.Bairro,
+		City:         data.Cidade,
+		State:        data.Estado,
+		IBGE:         data.CidadeInfo.CodigoIBGE,
+		Source:       "Postmon",
+		Service:      "postmon",
+	}
+}
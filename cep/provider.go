@@ -0,0 +1,377 @@
+package cep
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CEPProvider is implemented by every API that can resolve a CEP
+type CEPProvider interface {
+	Name() string
+	Fetch(ctx context.Context, cep string) (Response, error)
+}
+
+// URLProvider is implemented by providers that fetch a CEP with a single GET
+// request, so callers can inspect the URL without making the request
+type URLProvider interface {
+	URL(cep string) string
+}
+
+// DefaultProviders returns the providers raced by FetchFastest: the ones of
+// this package, all sharing client, followed by the registered ones. A nil
+// client means http.DefaultClient.
+func DefaultProviders(client *http.Client) []CEPProvider {
+	return append([]CEPProvider{
+		BrasilAPIProvider{Client: client},
+		ViaCEPProvider{Client: client},
+		PostmonProvider{Client: client},
+		OpenCEPProvider{Client: client},
+	}, RegisteredProviders()...)
+}
+
+// Retries is the number of extra attempts made after a transient failure
+// (network error or 5xx status). 4xx responses are never retried.
+var Retries = 2
+
+// RetryBackoff is the delay before the first retry; it doubles on each attempt
+var RetryBackoff = 100 * time.Millisecond
+
+// Jitter strategies for RetryJitter, which keep lookups that failed together
+// from retrying in lockstep
+const (
+	JitterNone         = "none"         // Wait exactly the backoff
+	JitterFull         = "full"         // Wait a random time up to the backoff
+	JitterDecorrelated = "decorrelated" // Wait between RetryBackoff and 3x the previous wait
+)
+
+// RetryJitter is the jitter strategy applied to the retry backoff
+var RetryJitter = JitterFull
+
+// retryDelay returns the wait before a retry, given the current exponential
+// backoff and the previous wait
+func retryDelay(backoff, prev time.Duration) time.Duration {
+	switch RetryJitter {
+	case JitterFull:
+		return time.Duration(rand.Int63n(int64(backoff) + 1))
+	case JitterDecorrelated:
+		if upper := 3 * prev; upper > RetryBackoff {
+			return RetryBackoff + time.Duration(rand.Int63n(int64(upper-RetryBackoff)))
+		}
+		return RetryBackoff
+	}
+	return backoff
+}
+
+// UserAgent is sent in the User-Agent header of every outbound request
+var UserAgent = "golang-multithreading-cep/1.0"
+
+// Logger receives a debug event for each step of every request when
+// non-nil, with the provider and the request ID from ctx as attributes, and
+// a warning for each disk cache file that can't be read or written
+var Logger *slog.Logger
+
+// debugEnabled reports whether logf would write anything
+func debugEnabled(ctx context.Context) bool {
+	return Logger != nil && Logger.Enabled(ctx, slog.LevelDebug)
+}
+
+// logf writes a debug event about provider to Logger if it is enabled
+func logf(ctx context.Context, provider, format string, args ...interface{}) {
+	if !debugEnabled(ctx) {
+		return
+	}
+	attrs := []interface{}{slog.String("provider", provider)}
+	if id := RequestID(ctx); id != "" {
+		attrs = append(attrs, slog.String("request_id", id))
+	}
+	Logger.DebugContext(ctx, fmt.Sprintf(format, args...), attrs...)
+}
+
+// TLSObserver, when non-nil, is called with the connection state of every
+// HTTPS response, reused connections included. It may be called from
+// several goroutines at once.
+var TLSObserver func(provider string, state *tls.ConnectionState)
+
+// MaxResponseSize caps the body read from a successful response, so a
+// misbehaving endpoint can't exhaust memory. Zero or less means no cap.
+var MaxResponseSize int64 = 1 << 20
+
+// limitedBody returns the first n bytes of r, failing with
+// ErrResponseTooLarge if there are more. Unlike io.LimitReader, a body cut
+// at the limit isn't mistaken for a complete one.
+func limitedBody(r io.Reader, n int64) io.Reader {
+	if n <= 0 {
+		return r
+	}
+	return &sizeLimiter{r: io.LimitReader(r, n+1), limit: n}
+}
+
+// sizeLimiter fails once more than limit bytes are read
+type sizeLimiter struct {
+	r     io.Reader
+	limit int64
+	read  int64
+}
+
+// Read trims the read that crosses the limit to the bytes under it; that
+// read and every later one fail
+func (l *sizeLimiter) Read(p []byte) (int, error) {
+	if l.read > l.limit {
+		return 0, l.tooLarge()
+	}
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+	if over := l.read - l.limit; over > 0 {
+		return n - int(over), l.tooLarge()
+	}
+	return n, err
+}
+
+func (l *sizeLimiter) tooLarge() error {
+	return fmt.Errorf("%w de %d bytes", ErrResponseTooLarge, l.limit)
+}
+
+// maxErrorBody is how much of a non-200 response body is kept in the error
+const maxErrorBody = 4 << 10
+
+// snippetSize is how much of the body a DecodeError quotes
+const snippetSize = 200
+
+// headBuffer keeps the first limit bytes written to it and discards the rest
+type headBuffer struct {
+	buf   []byte
+	limit int
+}
+
+func (h *headBuffer) Write(p []byte) (int, error) {
+	if room := h.limit - len(h.buf); room > 0 {
+		if len(p) < room {
+			room = len(p)
+		}
+		h.buf = append(h.buf, p[:room]...)
+	}
+	return len(p), nil
+}
+
+// String returns the kept bytes, dropping a UTF-8 sequence cut at the limit
+func (h *headBuffer) String() string {
+	return strings.ToValidUTF8(string(h.buf), "")
+}
+
+// getJSON performs a GET request and decodes the JSON body into v, retrying
+// transient failures with jittered exponential backoff until ctx is done. The body is
+// validated against s first, unless s is nil.
+func getJSON(ctx context.Context, client *http.Client, name, url string, s *schema, v interface{}) error {
+	backoff, delay := RetryBackoff, RetryBackoff
+	for attempt := 0; ; attempt++ {
+		err := doGetJSON(ctx, client, name, url, s, v)
+		if err == nil || !temporary(err) || attempt >= retries(ctx) || ctx.Err() != nil {
+			return err
+		}
+		delay = retryDelay(backoff, delay)
+		var statusErr *HTTPStatusError
+		if errors.As(err, &statusErr) && statusErr.RetryAfter > 0 {
+			// The provider said when to come back; waiting past the
+			// deadline would only hold the lookup up
+			wait := statusErr.RetryAfter
+			if MaxRetryAfter > 0 && wait > MaxRetryAfter {
+				wait = MaxRetryAfter
+			}
+			if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+				return err
+			}
+			delay = wait
+		}
+		logf(ctx, name, "falha temporária (%v), nova tentativa em %s", err, delay)
+
+		if !sleep(ctx, delay) {
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+// MaxRetryAfter caps the wait asked for by a Retry-After header before a
+// retry. Zero or less means no cap beyond the context deadline.
+var MaxRetryAfter = 5 * time.Second
+
+// parseRetryAfter reads a Retry-After header, either delay-seconds or an
+// HTTP-date, as the wait from now. A date in the past means no wait.
+func parseRetryAfter(header string, now time.Time) (time.Duration, bool) {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(header); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	at, err := http.ParseTime(header)
+	if err != nil {
+		return 0, false
+	}
+	if wait := at.Sub(now); wait > 0 {
+		return wait, true
+	}
+	return 0, true
+}
+
+// sleep waits for d and reports whether it elapsed before ctx was done
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// doGetJSON performs a single GET attempt, conditional when ctx carries a
+// Cache with a validated body for url. Failures are reported as
+// *NotFoundError, *HTTPStatusError, *NetworkError or *DecodeError.
+func doGetJSON(ctx context.Context, client *http.Client, name, url string, s *schema, v interface{}) error {
+	if err := waitLimiter(ctx); err != nil {
+		return &NetworkError{Provider: name, Err: err}
+	}
+
+	reqCtx := ctx
+	var timing *phases
+	if debugEnabled(ctx) {
+		reqCtx, timing = withTrace(ctx)
+	}
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", UserAgent)
+	if id := RequestID(ctx); id != "" {
+		req.Header.Set(RequestIDHeader, id)
+	}
+	setHeaders(ctx, req)
+	cache := requestCache(ctx)
+	var prev validated
+	var kept bool
+	if cache != nil {
+		if prev, kept = cache.validated(url); kept {
+			if prev.etag != "" {
+				req.Header.Set("If-None-Match", prev.etag)
+			}
+			if prev.lastModified != "" {
+				req.Header.Set("If-Modified-Since", prev.lastModified)
+			}
+		}
+	}
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+	logf(ctx, name, "requisição iniciada: %s", url)
+	if h := maskedHeaders(ctx); h != "" {
+		logf(ctx, name, "cabeçalhos extras: %s", h)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		logf(ctx, name, "erro na requisição: %v", err)
+		return &NetworkError{Provider: name, Err: err}
+	}
+	defer resp.Body.Close()
+	logf(ctx, name, "resposta recebida: status %d", resp.StatusCode)
+	if resp.TLS != nil && TLSObserver != nil {
+		TLSObserver(name, resp.TLS)
+	}
+	if timing != nil {
+		logf(ctx, name, "tempos: %s", timing)
+	}
+
+	var src io.Reader = limitedBody(resp.Body, MaxResponseSize)
+	switch {
+	case resp.StatusCode == http.StatusNotModified && kept:
+		logf(ctx, name, "resposta não modificada, usando o corpo guardado")
+		src = bytes.NewReader(prev.body)
+		cache.keep(url, prev)
+	case resp.StatusCode != http.StatusOK:
+		// Keep the start of the body: some providers explain the failure
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		if resp.StatusCode == http.StatusNotFound {
+			return &NotFoundError{Provider: name, Body: body}
+		}
+		retryAfter, _ := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		return &HTTPStatusError{Provider: name, Code: resp.StatusCode, Body: body, RetryAfter: retryAfter}
+	}
+
+	// Providers that send no validators can't answer a conditional request,
+	// so there is no point keeping their body
+	etag, lastModified := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+	var saved *bytes.Buffer
+	if cache != nil && resp.StatusCode == http.StatusOK && (etag != "" || lastModified != "") {
+		saved = &bytes.Buffer{}
+		src = io.TeeReader(src, saved)
+	}
+
+	if err := decodeBody(ctx, name, src, s, v); err != nil {
+		return err
+	}
+	if saved != nil {
+		cache.keep(url, validated{etag: etag, lastModified: lastModified, body: saved.Bytes()})
+	}
+	return nil
+}
+
+// decodeBody decodes a provider's JSON body into v, validating it against s
+// when there is a schema
+func decodeBody(ctx context.Context, name string, src io.Reader, s *schema, v interface{}) error {
+	// Remember the start of the body for decode errors
+	head := &headBuffer{limit: snippetSize}
+	body := io.TeeReader(src, head)
+
+	if s == nil {
+		// Decode straight from the body instead of buffering it first
+		if err := json.NewDecoder(body).Decode(v); err != nil {
+			logf(ctx, name, "erro na decodificação: %v", err)
+			return &DecodeError{Provider: name, Err: err, Snippet: head.String()}
+		}
+		logf(ctx, name, "decodificação concluída")
+		return nil
+	}
+
+	// Validating needs the whole document, so only the providers with a
+	// schema buffer the body
+	var raw json.RawMessage
+	if err := json.NewDecoder(body).Decode(&raw); err != nil {
+		logf(ctx, name, "erro na decodificação: %v", err)
+		return &DecodeError{Provider: name, Err: err, Snippet: head.String()}
+	}
+	var doc interface{}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return &DecodeError{Provider: name, Err: err, Snippet: head.String()}
+	}
+	if err := s.validate(doc, "$"); err != nil {
+		logf(ctx, name, "resposta fora do formato esperado: %v", err)
+		return &SchemaError{Provider: name, Err: err}
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return &DecodeError{Provider: name, Err: err, Snippet: head.String()}
+	}
+	logf(ctx, name, "decodificação concluída")
+	return nil
+}
+
+// notFound returns a *NotFoundError for the named provider
+func notFound(name string) error {
+	return &NotFoundError{Provider: name}
+}
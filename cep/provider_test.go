@@ -0,0 +1,760 @@
+package cep
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestServer serves body with status on every request, after delay
+func newTestServer(t *testing.T, status int, body string, delay time.Duration) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestProviders(t *testing.T) {
+	retries := Retries
+	Retries = 0
+	defer func() { Retries = retries }()
+
+	providers := map[string]struct {
+		body    string
+		want    Address
+		provide func(baseURL string) CEPProvider
+	}{
+		"BrasilAPI": {
+			body: `{"cep":"01153000","state":"SP","city":"São Paulo","neighborhood":"Barra Funda","street":"Rua Vitorino Carmilo","service":"open-cep"}`,
+			want: Address{CEP: "01153000", Street: "Rua Vitorino Carmilo", Neighborhood: "Barra Funda", City: "São Paulo", State: "SP", Source: "BrasilAPI", Service: "open-cep"},
+			provide: func(baseURL string) CEPProvider {
+				return BrasilAPIProvider{BaseURL: baseURL}
+			},
+		},
+		"ViaCEP": {
+			body: `{"cep":"01153-000","logradouro":"Rua Vitorino Carmilo","bairro":"Barra Funda","localidade":"São Paulo","uf":"SP","ibge":"3550308","ddd":"11"}`,
+			want: Address{CEP: "01153-000", Street: "Rua Vitorino Carmilo", Neighborhood: "Barra Funda", City: "São Paulo", State: "SP", IBGE: "3550308", DDD: "11", Source: "ViaCEP", Service: "viacep"},
+			provide: func(baseURL string) CEPProvider {
+				return ViaCEPProvider{BaseURL: baseURL}
+			},
+		},
+		"Postmon": {
+			body: `{"cep":"01153000","logradouro":"Rua Vitorino Carmilo","bairro":"Barra Funda","cidade":"São Paulo","estado":"SP","cidade_info":{"codigo_ibge":"3550308"}}`,
+			want: Address{CEP: "01153000", Street: "Rua Vitorino Carmilo", Neighborhood: "Barra Funda", City: "São Paulo", State: "SP", IBGE: "3550308", Source: "Postmon", Service: "postmon"},
+			provide: func(baseURL string) CEPProvider {
+				return PostmonProvider{BaseURL: baseURL}
+			},
+		},
+		"OpenCEP": {
+			body: `{"cep":"01153-000","logradouro":"Rua Vitorino Carmilo","bairro":"Barra Funda","localidade":"São Paulo","uf":"SP","ibge":"3550308"}`,
+			want: Address{CEP: "01153-000", Street: "Rua Vitorino Carmilo", Neighborhood: "Barra Funda", City: "São Paulo", State: "SP", IBGE: "3550308", Source: "OpenCEP", Service: "opencep"},
+			provide: func(baseURL string) CEPProvider {
+				return OpenCEPProvider{BaseURL: baseURL}
+			},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		status  int
+		body    string // Empty means the provider's canned success body
+		delay   time.Duration
+		timeout time.Duration
+		wantErr func(error) bool
+	}{
+		{name: "success", status: http.StatusOK},
+		{
+			name:    "not found",
+			status:  http.StatusNotFound,
+			body:    `{}`,
+			wantErr: func(err error) bool { return errors.Is(err, ErrCEPNotFound) },
+		},
+		{
+			name:   "server error",
+			status: http.StatusServiceUnavailable,
+			body:   `{}`,
+			wantErr: func(err error) bool {
+				var statusErr *HTTPStatusError
+				return errors.As(err, &statusErr) && statusErr.Code == http.StatusServiceUnavailable
+			},
+		},
+		{
+			name:   "malformed JSON",
+			status: http.StatusOK,
+			body:   `{"cep":`,
+			wantErr: func(err error) bool {
+				var decodeErr *DecodeError
+				return errors.As(err, &decodeErr) && decodeErr.Snippet == `{"cep":`
+			},
+		},
+		{
+			name:   "unexpected shape",
+			status: http.StatusOK,
+			body:   `{"cep":1153000}`,
+			wantErr: func(err error) bool {
+				var schemaErr *SchemaError
+				return errors.As(err, &schemaErr)
+			},
+		},
+		{
+			name:    "timeout",
+			status:  http.StatusOK,
+			delay:   time.Second,
+			timeout: 50 * time.Millisecond,
+			wantErr: func(err error) bool { return errors.Is(err, context.DeadlineExceeded) },
+		},
+	}
+
+	for name, p := range providers {
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				body := tt.body
+				if body == "" {
+					body = p.body
+				}
+				srv := newTestServer(t, tt.status, body, tt.delay)
+
+				timeout := tt.timeout
+				if timeout == 0 {
+					timeout = time.Second
+				}
+				ctx, cancel := context.WithTimeout(context.Background(), timeout)
+				defer cancel()
+
+				result := <-Race(ctx, "01153000", []CEPProvider{p.provide(srv.URL)})
+
+				if tt.wantErr != nil {
+					if !tt.wantErr(result.Error) {
+						t.Fatalf("unexpected error: %v", result.Error)
+					}
+					return
+				}
+				if result.Error != nil {
+					t.Fatalf("unexpected error: %v", result.Error)
+				}
+				if result.Address != p.want {
+					t.Errorf("got address %+v, want %+v", result.Address, p.want)
+				}
+				if result.APIName != name {
+					t.Errorf("got APIName %q, want %q", result.APIName, name)
+				}
+				if result.Duration <= 0 {
+					t.Errorf("got duration %s, want > 0", result.Duration)
+				}
+			})
+		}
+	}
+}
+
+func TestViaCEPErro(t *testing.T) {
+	srv := newTestServer(t, http.StatusOK, `{"erro": true}`, 0)
+
+	_, err := ViaCEPProvider{BaseURL: srv.URL}.Fetch(context.Background(), "99999999")
+	if !errors.Is(err, ErrCEPNotFound) {
+		t.Fatalf("got error %v, want ErrCEPNotFound", err)
+	}
+}
+
+func TestMaxResponseSize(t *testing.T) {
+	limit := MaxResponseSize
+	MaxResponseSize = 1024
+	defer func() { MaxResponseSize = limit }()
+
+	var hits int32
+	pad := strings.Repeat("x", 2048)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		fmt.Fprintf(w, `{"cep":"01153000","state":"SP","city":"São Paulo","street":"%s"}`, pad)
+	}))
+	defer srv.Close()
+
+	_, err := BrasilAPIProvider{BaseURL: srv.URL}.Fetch(context.Background(), "01153000")
+	if !errors.Is(err, ErrResponseTooLarge) {
+		t.Fatalf("got error %v, want ErrResponseTooLarge", err)
+	}
+	if hits != 1 {
+		t.Fatalf("got %d requests, want 1: oversized bodies must not be retried", hits)
+	}
+}
+
+func TestSizeLimiterKeepsFailing(t *testing.T) {
+	r := limitedBody(strings.NewReader("abcdef"), 4)
+	buf := make([]byte, 8)
+	if n, err := r.Read(buf); n != 4 || !errors.Is(err, ErrResponseTooLarge) {
+		t.Fatalf("first Read = %d, %v; want 4, ErrResponseTooLarge", n, err)
+	}
+	if n, err := r.Read(buf); n != 0 || !errors.Is(err, ErrResponseTooLarge) {
+		t.Fatalf("second Read = %d, %v; want 0, ErrResponseTooLarge", n, err)
+	}
+}
+
+func TestBrasilAPIV2Coordinates(t *testing.T) {
+	srv := newTestServer(t, http.StatusOK, `{"cep":"01153000","state":"SP","city":"São Paulo","neighborhood":"Barra Funda","street":"Rua Vitorino Carmilo","service":"open-cep","location":{"type":"Point","coordinates":{"longitude":"-46.6521","latitude":"-23.5266"}}}`, 0)
+
+	resp, err := BrasilAPIProvider{BaseURL: srv.URL, Version: 2}.Fetch(context.Background(), "01153000")
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if resp.Address.Latitude != -23.5266 || resp.Address.Longitude != -46.6521 {
+		t.Fatalf("got coordinates %v, %v, want -23.5266, -46.6521", resp.Address.Latitude, resp.Address.Longitude)
+	}
+}
+
+func TestBrasilAPIErrorBody(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{
+			name: "error body",
+			body: `{"name":"CepPromiseError","message":"Todos os serviços de CEP retornaram erro.","type":"service_error","errors":[{"name":"ServiceError","message":"CEP NAO ENCONTRADO","service":"correios"}]}`,
+			want: "Todos os serviços de CEP retornaram erro. (correios: CEP NAO ENCONTRADO)",
+		},
+		{name: "unexpected body", body: `<html>not found</html>`, want: "CEP não encontrado na BrasilAPI"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t, http.StatusNotFound, tt.body, 0)
+
+			_, err := BrasilAPIProvider{BaseURL: srv.URL}.Fetch(context.Background(), "99999999")
+			if !errors.Is(err, ErrCEPNotFound) {
+				t.Fatalf("got error %v, want ErrCEPNotFound", err)
+			}
+			if err.Error() != tt.want {
+				t.Fatalf("got message %q, want %q", err.Error(), tt.want)
+			}
+		})
+	}
+}
+
+func TestIncompleteRetries(t *testing.T) {
+	old, oldBackoff := IncompleteRetries, RetryBackoff
+	IncompleteRetries, RetryBackoff = 2, time.Millisecond
+	defer func() { IncompleteRetries, RetryBackoff = old, oldBackoff }()
+
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) == 1 {
+			w.Write([]byte(`{"cep":"01153000","state":"SP","city":"São Paulo","neighborhood":"","street":""}`))
+			return
+		}
+		w.Write([]byte(`{"cep":"01153000","state":"SP","city":"São Paulo","neighborhood":"Barra Funda","street":"Rua Vitorino Carmilo"}`))
+	}))
+	defer srv.Close()
+
+	result := <-Race(context.Background(), "01153000", []CEPProvider{BrasilAPIProvider{BaseURL: srv.URL}})
+	if result.Error != nil {
+		t.Fatalf("unexpected error: %v", result.Error)
+	}
+	if !result.Address.Complete() {
+		t.Fatalf("got incomplete address %+v", result.Address)
+	}
+	if hits != 2 {
+		t.Fatalf("got %d requests, want 2", hits)
+	}
+}
+
+func TestRaceRejectsAnotherCEP(t *testing.T) {
+	srv := newTestServer(t, http.StatusOK, `{"cep":"20040-002","state":"RJ","city":"Rio de Janeiro","neighborhood":"Centro","street":"Rua da Assembleia"}`, 0)
+
+	result := <-Race(context.Background(), "01153000", []CEPProvider{BrasilAPIProvider{BaseURL: srv.URL}})
+	if !errors.Is(result.Error, ErrCEPMismatch) {
+		t.Fatalf("got error %v, want ErrCEPMismatch", result.Error)
+	}
+
+	// The dash alone is not a mismatch
+	srv = newTestServer(t, http.StatusOK, `{"cep":"01153-000","state":"SP","city":"São Paulo","neighborhood":"Barra Funda","street":"Rua Vitorino Carmilo"}`, 0)
+	if result := <-Race(context.Background(), "01153000", []CEPProvider{BrasilAPIProvider{BaseURL: srv.URL}}); result.Error != nil {
+		t.Fatalf("unexpected error: %v", result.Error)
+	}
+}
+
+// staticProvider answers immediately with a fixed address
+type staticProvider struct{ name string }
+
+func (p staticProvider) Name() string { return p.name }
+
+func (p staticProvider) Fetch(context.Context, string) (Response, error) {
+	return Response{Address: Address{CEP: "01153000"}}, nil
+}
+
+func TestRaceDoesNotBlockWithoutReader(t *testing.T) {
+	var providers []CEPProvider
+	for i := 0; i < 5; i++ {
+		providers = append(providers, staticProvider{name: fmt.Sprintf("P%d", i)})
+	}
+
+	results := Race(context.Background(), "01153000", providers)
+
+	// Every provider must be able to report without anyone reading, which
+	// only holds when the buffer matches the number of providers
+	deadline := time.Now().Add(time.Second)
+	for len(results) < len(providers) {
+		if time.Now().After(deadline) {
+			t.Fatalf("only %d of %d providers reported", len(results), len(providers))
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for range results {
+	}
+}
+
+// failingProvider fails every lookup with err
+type failingProvider struct {
+	name string
+	err  error
+}
+
+func (p failingProvider) Name() string { return p.name }
+
+func (p failingProvider) Fetch(context.Context, string) (Response, error) {
+	return Response{}, p.err
+}
+
+// switchProvider fails with err, which the test changes between lookups
+type switchProvider struct{ err error }
+
+func (p *switchProvider) Name() string { return "Switch" }
+
+func (p *switchProvider) Fetch(context.Context, string) (Response, error) {
+	return Response{Address: Address{CEP: "01153000"}}, p.err
+}
+
+func TestBreakerStates(t *testing.T) {
+	down := errors.New("fora do ar")
+	steps := []struct {
+		name      string
+		advance   time.Duration // Clock moves before the lookup
+		err       error         // Returned by the provider
+		wantErr   error
+		wantState string // After the lookup
+	}{
+		{"first failure", 0, down, down, CircuitClosed},
+		{"success resets", 0, nil, nil, CircuitClosed},
+		{"failure 1 of 2", 0, down, down, CircuitClosed},
+		{"not found isn't a failure", 0, ErrCEPNotFound, ErrCEPNotFound, CircuitClosed},
+		{"failure 1 again", 0, down, down, CircuitClosed},
+		{"threshold opens", 0, down, down, CircuitOpen},
+		{"open fails fast", 30 * time.Second, nil, ErrCircuitOpen, CircuitOpen},
+		{"failed probe reopens", 30 * time.Second, down, down, CircuitOpen},
+		{"still open", 59 * time.Second, nil, ErrCircuitOpen, CircuitOpen},
+		{"probe closes", time.Second, nil, nil, CircuitClosed},
+	}
+
+	p := &switchProvider{}
+	b := NewBreaker(p, 2, time.Minute)
+	now := time.Now()
+	b.now = func() time.Time { return now }
+	for _, step := range steps {
+		now = now.Add(step.advance)
+		p.err = step.err
+		if _, err := b.Fetch(context.Background(), "01153000"); !errors.Is(err, step.wantErr) {
+			t.Fatalf("%s: error = %v, want %v", step.name, err, step.wantErr)
+		}
+		if got := b.State().State; got != step.wantState {
+			t.Fatalf("%s: state = %s, want %s", step.name, got, step.wantState)
+		}
+	}
+
+	// Once the cooldown is over the circuit reports half-open until probed
+	p.err = down
+	b.Fetch(context.Background(), "01153000")
+	b.Fetch(context.Background(), "01153000")
+	now = now.Add(time.Minute)
+	if got := b.State().State; got != CircuitHalfOpen {
+		t.Fatalf("state after the cooldown = %s, want %s", got, CircuitHalfOpen)
+	}
+}
+
+func TestFetchAllReturnsEveryResponse(t *testing.T) {
+	down := errors.New("fora do ar")
+	client := New(WithProviders(staticProvider{name: "A"}, failingProvider{name: "B", err: down}, staticProvider{name: "C"}))
+
+	all, err := client.FetchAll(context.Background(), "01153-000")
+	if err != nil {
+		t.Fatalf("FetchAll: %v", err)
+	}
+	got := map[string]error{}
+	for _, r := range all {
+		got[r.APIName] = r.Error
+	}
+	if len(all) != 3 || got["A"] != nil || got["C"] != nil || !errors.Is(got["B"], down) {
+		t.Fatalf("got %+v, want A and C succeeding and B failing", all)
+	}
+
+	if _, err := client.FetchAll(context.Background(), "123"); !errors.Is(err, ErrInvalidCEP) {
+		t.Fatalf("got error %v, want ErrInvalidCEP", err)
+	}
+}
+
+func TestRegisterProvider(t *testing.T) {
+	defer func() { registry.providers = nil }()
+
+	RegisterProvider("MinhaAPI", staticProvider{name: "interna"})
+
+	providers := DefaultProviders(nil)
+	registered := providers[len(providers)-1]
+	if registered.Name() != "MinhaAPI" {
+		t.Fatalf("last default provider is %q, want the registered MinhaAPI", registered.Name())
+	}
+	resp, err := registered.Fetch(context.Background(), "01153000")
+	if err != nil || resp.APIName != "MinhaAPI" || resp.Address.CEP != "01153000" {
+		t.Fatalf("Fetch = %+v, %v", resp, err)
+	}
+
+	for _, name := range []string{"minhaapi", "ViaCEP", ""} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("RegisterProvider(%q) didn't panic", name)
+				}
+			}()
+			RegisterProvider(name, staticProvider{name: name})
+		}()
+	}
+}
+
+func TestLimiterSpacesRequests(t *testing.T) {
+	l := NewLimiter(100, 1)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := l.Wait(context.Background()); err != nil {
+			t.Fatalf("Wait: %v", err)
+		}
+	}
+	// The first token is free, the other two take 10ms each
+	if elapsed := time.Since(start); elapsed < 15*time.Millisecond {
+		t.Fatalf("3 requests at 100/s took %v, want about 20ms", elapsed)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	slow := NewLimiter(0.1, 1)
+	slow.Wait(ctx)
+	if err := slow.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Wait error = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func FuzzNormalizeCEP(f *testing.F) {
+	for _, seed := range []string{"01153000", "01153-000", "1153000", " 01.153-000 ", "", "٠١١٥٣٠٠٠", "0115\x003000", strings.Repeat("9", 1<<12)} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, raw string) {
+		got, err := Normalize(raw)
+		if err != nil {
+			if got != "" {
+				t.Fatalf("Normalize(%q) = %q with error %v, want an empty value", raw, got, err)
+			}
+			return
+		}
+		if len(got) != 8 {
+			t.Fatalf("Normalize(%q) = %q, want 8 digits", raw, got)
+		}
+		for i := 0; i < len(got); i++ {
+			if got[i] < '0' || got[i] > '9' {
+				t.Fatalf("Normalize(%q) = %q, want only ASCII digits", raw, got)
+			}
+		}
+		if again, err := Normalize(got); err != nil || again != got {
+			t.Fatalf("Normalize(%q) = %q, %v, want it unchanged", got, again, err)
+		}
+	})
+}
+
+func TestConditionalRequests(t *testing.T) {
+	var notModified int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt32(&notModified, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(`{"cep":"01153000","state":"SP","city":"São Paulo","neighborhood":"Barra Funda","street":"Rua Vitorino Carmilo"}`))
+	}))
+	defer srv.Close()
+
+	c := New(WithProviders(BrasilAPIProvider{BaseURL: srv.URL}), WithCache(NewCache(time.Hour)))
+	for i := 0; i < 2; i++ {
+		resp, err := c.Lookup(context.Background(), "01153000")
+		if err != nil {
+			t.Fatalf("lookup %d: %v", i+1, err)
+		}
+		if resp.Address.Street != "Rua Vitorino Carmilo" {
+			t.Fatalf("lookup %d: got street %q", i+1, resp.Address.Street)
+		}
+	}
+	if notModified != 1 {
+		t.Fatalf("got %d conditional hits, want 1", notModified)
+	}
+}
+
+func TestValidatedBodiesExpire(t *testing.T) {
+	c := NewCache(10 * time.Millisecond)
+	c.keep("http://api/01153000", validated{etag: `"v1"`, body: []byte("{}")})
+	if _, ok := c.validated("http://api/01153000"); !ok {
+		t.Fatal("body missing right after keep")
+	}
+
+	time.Sleep(25 * time.Millisecond)
+	if _, ok := c.validated("http://api/01153000"); ok {
+		t.Fatal("body still served two TTLs after it was kept")
+	}
+	c.keep("http://api/01001000", validated{etag: `"v1"`, body: []byte("{}")})
+	if _, ok := c.bodies["http://api/01153000"]; ok {
+		t.Fatal("expired body not swept")
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)
+	tests := []struct {
+		header string
+		want   time.Duration
+		ok     bool
+	}{
+		{"3", 3 * time.Second, true},
+		{now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second, true},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0, true},
+		{"", 0, false},
+		{"-1", 0, false},
+		{"soon", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseRetryAfter(tt.header, now)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseRetryAfter(%q) = %v, %v, want %v, %v", tt.header, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	oldRetries, oldMax := Retries, MaxRetryAfter
+	Retries, MaxRetryAfter = 1, 50*time.Millisecond
+	defer func() { Retries, MaxRetryAfter = oldRetries, oldMax }()
+
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) == 1 {
+			w.Header().Set("Retry-After", "120")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte(`{"cep":"01153000","state":"SP","city":"São Paulo","neighborhood":"Barra Funda","street":"Rua Vitorino Carmilo"}`))
+	}))
+	defer srv.Close()
+
+	start := time.Now()
+	if _, err := (BrasilAPIProvider{BaseURL: srv.URL}).Fetch(context.Background(), "01153000"); err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	// The 120s asked for are capped by MaxRetryAfter
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed > time.Second {
+		t.Fatalf("retry after %v, want about 50ms", elapsed)
+	}
+	if hits != 2 {
+		t.Fatalf("got %d requests, want 2", hits)
+	}
+}
+
+func TestFirstN(t *testing.T) {
+	results := make(chan Response, 4)
+	results <- Response{APIName: "a"}
+	results <- Response{APIName: "b", Error: errors.New("boom")}
+	results <- Response{APIName: "c"}
+	results <- Response{APIName: "d"}
+	close(results)
+
+	got, err := FirstN(context.Background(), results, 2)
+	if err != nil {
+		t.Fatalf("FirstN: %v", err)
+	}
+	if len(got) != 2 || got[0].APIName != "a" || got[1].APIName != "c" {
+		t.Fatalf("got %+v, want a and c", got)
+	}
+
+	// Fewer successes than asked for still return what there is
+	results = make(chan Response, 2)
+	results <- Response{APIName: "a"}
+	results <- Response{APIName: "b", Error: errors.New("boom")}
+	close(results)
+	if got, err := FirstN(context.Background(), results, 2); err != nil || len(got) != 1 {
+		t.Fatalf("got %+v, %v, want only a", got, err)
+	}
+}
+
+// benchmarkDecode decodes payload into a fresh T on each iteration, once
+// validated against the provider's schema as Fetch does and once streamed
+// without one, so the cost of validation shows up apart
+func benchmarkDecode[T any](b *testing.B, provider, payload string, convert func(T) Address) {
+	ctx := context.Background()
+	for _, bc := range []struct {
+		name   string
+		schema *schema
+	}{
+		{"schema", schemaFor(provider)},
+		{"stream", nil},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(payload)))
+			for i := 0; i < b.N; i++ {
+				var data T
+				if err := decodeBody(ctx, provider, strings.NewReader(payload), bc.schema, &data); err != nil {
+					b.Fatal(err)
+				}
+				convert(data)
+			}
+		})
+	}
+}
+
+func BenchmarkDecodeBrasilAPI(b *testing.B) {
+	benchmarkDecode(b, "BrasilAPI", `{"cep":"01153000","state":"SP","city":"São Paulo","neighborhood":"Barra Funda","street":"Rua Vitorino Carmilo","service":"open-cep","location":{"type":"Point","coordinates":{"longitude":"-46.6521","latitude":"-23.5266"}}}`, fromBrasilAPI)
+}
+
+func BenchmarkDecodeViaCEP(b *testing.B) {
+	benchmarkDecode(b, "ViaCEP", `{"cep":"01153-000","logradouro":"Rua Vitorino Carmilo","complemento":"","unidade":"","bairro":"Barra Funda","localidade":"São Paulo","uf":"SP","estado":"São Paulo","regiao":"Sudeste","ibge":"3550308","gia":"1004","ddd":"11","siafi":"7107"}`, fromViaCEP)
+}
+
+func TestLookupTimeoutLeavesNoGoroutines(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(10 * time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	// Without keep-alives no idle connection outlives the lookup
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	c := New(WithProviders(
+		BrasilAPIProvider{Client: client, BaseURL: srv.URL},
+		ViaCEPProvider{Client: client, BaseURL: srv.URL},
+		PostmonProvider{Client: client, BaseURL: srv.URL},
+	), WithTimeout(20*time.Millisecond))
+
+	before := runtime.NumGoroutine()
+	if _, err := c.Lookup(context.Background(), "01153000"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Lookup error = %v, want context.DeadlineExceeded", err)
+	}
+
+	// Canceled requests unwind asynchronously, on both ends of the
+	// connection, so give them a moment
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<16)
+			t.Fatalf("%d goroutines left after the lookup, want at most %d:\n%s",
+				runtime.NumGoroutine(), before, buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTLSObserver(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"cep":"01153000","state":"SP","city":"São Paulo","neighborhood":"Barra Funda","street":"Rua Vitorino Carmilo"}`))
+	}))
+	defer srv.Close()
+
+	var got []*tls.ConnectionState
+	TLSObserver = func(provider string, state *tls.ConnectionState) { got = append(got, state) }
+	defer func() { TLSObserver = nil }()
+
+	if _, err := (BrasilAPIProvider{Client: srv.Client(), BaseURL: srv.URL}).Fetch(context.Background(), "01153000"); err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if len(got) != 1 || len(got[0].PeerCertificates) == 0 {
+		t.Fatalf("got %d TLS states, want one with the server certificate", len(got))
+	}
+}
+
+func TestTunedHeaders(t *testing.T) {
+	var got http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		w.Write([]byte(`{"cep":"01153000","state":"SP","city":"São Paulo","neighborhood":"Barra Funda","street":"Rua Vitorino Carmilo"}`))
+	}))
+	defer srv.Close()
+
+	p := Tuned{
+		CEPProvider: BrasilAPIProvider{Client: srv.Client(), BaseURL: srv.URL},
+		Header:      http.Header{"Authorization": {"Bearer s3cr3t"}, "User-Agent": {"custom"}},
+	}
+	if _, err := p.Fetch(context.Background(), "01153000"); err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if v := got.Get("Authorization"); v != "Bearer s3cr3t" {
+		t.Errorf("Authorization = %q, want %q", v, "Bearer s3cr3t")
+	}
+	if v := got.Get("User-Agent"); v != "custom" {
+		t.Errorf("User-Agent = %q, want the configured one", v)
+	}
+	if m := mask("Bearer s3cr3t"); m != "Bearer ****" {
+		t.Errorf("mask = %q, want %q", m, "Bearer ****")
+	}
+}
+
+func TestDiskCache(t *testing.T) {
+	dir := t.TempDir()
+	c, err := NewDiskCache(dir, time.Hour)
+	if err != nil {
+		t.Fatalf("NewDiskCache: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c.Set("01153000", Response{Address: Address{CEP: "01153000", City: fmt.Sprint("São Paulo ", i), Source: "BrasilAPI"}})
+		}(i)
+	}
+	wg.Wait()
+
+	// A fresh Cache on the same directory stands for the next run
+	next, _ := NewDiskCache(dir, time.Hour)
+	resp, ok := next.Get("01153000")
+	if !ok || resp.APIName != CacheSource || resp.Address.Source != "BrasilAPI" {
+		t.Fatalf("Get = %+v, %v; want the BrasilAPI entry served from the cache", resp, ok)
+	}
+	expired, _ := NewDiskCache(dir, 0)
+	if _, ok := expired.Get("01153000"); ok {
+		t.Error("Get returned an entry older than the TTL")
+	}
+
+	// Files the cache didn't create must survive Clear, unlike the leftover
+	// of a write interrupted before its rename
+	other := filepath.Join(dir, "package.json")
+	if err := os.WriteFile(other, []byte("{}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "01310100.json.123.tmp"), []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if n := next.Clear(); n != 1 {
+		t.Errorf("Clear = %d, want 1", n)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*")); len(files) != 1 || files[0] != other {
+		t.Errorf("files left after Clear: %v, want only %s", files, other)
+	}
+}
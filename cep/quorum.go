@@ -0,0 +1,103 @@
+package cep
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// KeyDiffs compares the key fields of a and b (street, city and state) and
+// returns the ones that differ. Unlike DiffAddresses, values are compared
+// ignoring case, surrounding whitespace and accents. The neighborhood is
+// left out because providers often abbreviate it differently.
+func KeyDiffs(a, b Address) []FieldDiff {
+	fields := []FieldDiff{
+		{"Street", a.Street, b.Street},
+		{"City", a.City, b.City},
+		{"State", a.State, b.State},
+	}
+
+	var diffs []FieldDiff
+	for _, f := range fields {
+		if fold(f.A) != fold(f.B) {
+			diffs = append(diffs, f)
+		}
+	}
+	return diffs
+}
+
+// DisagreementError is returned by Quorum when two providers answer with
+// different key fields
+type DisagreementError struct {
+	A, B  Response
+	Diffs []FieldDiff
+}
+
+func (e *DisagreementError) Error() string {
+	msgs := make([]string, len(e.Diffs))
+	for i, d := range e.Diffs {
+		msgs[i] = fmt.Sprintf("%s (%s: %q, %s: %q)", d.Field, e.A.APIName, d.A, e.B.APIName, d.B)
+	}
+	return "as APIs divergem: " + strings.Join(msgs, "; ")
+}
+
+// QuorumError is returned by Quorum when the providers finish with fewer
+// successful responses than required
+type QuorumError struct {
+	Need     int
+	Answers  []Response
+	Failures []Response
+}
+
+func (e *QuorumError) Error() string {
+	msgs := make([]string, len(e.Failures))
+	for i, f := range e.Failures {
+		msgs[i] = fmt.Sprintf("%s: %v", f.APIName, f.Error)
+	}
+	return fmt.Sprintf("apenas %d de %d APIs necessárias responderam: %s",
+		len(e.Answers), e.Need, strings.Join(msgs, "; "))
+}
+
+// Unwrap exposes each failed provider's error
+func (e *QuorumError) Unwrap() []error {
+	errs := make([]error, len(e.Failures))
+	for i, f := range e.Failures {
+		errs[i] = f.Error
+	}
+	return errs
+}
+
+// Quorum reads results until n providers have answered successfully and
+// returns their responses in arrival order. Every answer is checked against
+// the first one with KeyDiffs, and a *DisagreementError is returned as soon
+// as one differs. If the providers finish before n answers arrive it
+// returns a *QuorumError, or ctx.Err() when the context expired.
+func Quorum(ctx context.Context, results <-chan Response, n int) ([]Response, error) {
+	var answers, failures []Response
+	for {
+		select {
+		case result, ok := <-results:
+			if !ok {
+				if err := ctx.Err(); err != nil {
+					return nil, err
+				}
+				return nil, &QuorumError{Need: n, Answers: answers, Failures: failures}
+			}
+			if result.Error != nil {
+				failures = append(failures, result)
+				continue
+			}
+			if len(answers) > 0 {
+				if diffs := KeyDiffs(answers[0].Address, result.Address); len(diffs) > 0 {
+					return nil, &DisagreementError{A: answers[0], B: result, Diffs: diffs}
+				}
+			}
+			answers = append(answers, result)
+			if len(answers) >= n {
+				return answers, nil
+			}
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
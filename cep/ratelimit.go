@@ -0,0 +1,88 @@
+package cep
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket allowing rate requests per second on average,
+// with bursts of up to burst requests. It is safe for concurrent use.
+type Limiter struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64 // Negative when callers are already queued for tokens
+	last   time.Time
+}
+
+// NewLimiter returns a full Limiter allowing rate requests per second and
+// bursts of burst requests. rate must be positive; burst is at least 1.
+func NewLimiter(rate float64, burst int) *Limiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &Limiter{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// Wait blocks until a request may be made or ctx is done, in which case it
+// returns ctx.Err() and gives the reserved token back
+func (l *Limiter) Wait(ctx context.Context) error {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	// Reserve the token up front, so waiters are served in arrival order
+	l.tokens--
+	delay := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	if !sleep(ctx, delay) {
+		l.mu.Lock()
+		l.tokens++
+		l.mu.Unlock()
+		return ctx.Err()
+	}
+	return nil
+}
+
+// RateLimited wraps a provider so its outbound requests, retries included,
+// go through Limiter
+type RateLimited struct {
+	CEPProvider
+	Limiter *Limiter
+}
+
+// URL returns the wrapped provider's URL for cep, if it exposes one
+func (r RateLimited) URL(cep string) string {
+	if up, ok := r.CEPProvider.(URLProvider); ok {
+		return up.URL(cep)
+	}
+	return ""
+}
+
+// Fetch queries the wrapped provider, throttled by r.Limiter
+func (r RateLimited) Fetch(ctx context.Context, cep string) (Response, error) {
+	return r.CEPProvider.Fetch(context.WithValue(ctx, limiterKey{}, r.Limiter), cep)
+}
+
+// Unwrap returns the wrapped provider
+func (r RateLimited) Unwrap() CEPProvider { return r.CEPProvider }
+
+// limiterKey is the context key of a RateLimited provider's Limiter
+type limiterKey struct{}
+
+// waitLimiter waits for the Limiter of the requests made with ctx, if any
+func waitLimiter(ctx context.Context) error {
+	if l, ok := ctx.Value(limiterKey{}).(*Limiter); ok {
+		return l.Wait(ctx)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package cep
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// registry holds the providers added with RegisterProvider, in order
+var registry struct {
+	sync.Mutex
+	providers []CEPProvider
+}
+
+// builtinNames are the names taken by the providers of this package
+var builtinNames = []string{"brasilapi", "viacep", "postmon", "opencep"}
+
+// RegisterProvider adds p under name to the providers returned by
+// DefaultProviders, so a downstream package can plug in its own API from an
+// init function; the CLI lets -providers select it by name. p keeps its own
+// HTTP client. It panics if name is empty or already taken, compared
+// case-insensitively, like database/sql.Register.
+func RegisterProvider(name string, p CEPProvider) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	if name == "" || p == nil {
+		panic("cep: RegisterProvider com nome vazio ou provider nil")
+	}
+	key := strings.ToLower(name)
+	taken := false
+	for _, builtin := range builtinNames {
+		taken = taken || builtin == key
+	}
+	for _, registered := range registry.providers {
+		taken = taken || strings.ToLower(registered.Name()) == key
+	}
+	if taken {
+		panic(fmt.Sprintf("cep: RegisterProvider chamado duas vezes para %q", name))
+	}
+
+	if p.Name() != name {
+		p = namedProvider{CEPProvider: p, name: name}
+	}
+	registry.providers = append(registry.providers, p)
+}
+
+// RegisteredProviders returns the providers added with RegisterProvider, in
+// registration order
+func RegisteredProviders() []CEPProvider {
+	registry.Lock()
+	defer registry.Unlock()
+	return append([]CEPProvider(nil), registry.providers...)
+}
+
+// namedProvider reports a registered provider under its registration name
+type namedProvider struct {
+	CEPProvider
+	name string
+}
+
+func (p namedProvider) Name() string { return p.name }
+
+// Fetch queries the wrapped provider, reporting the registration name
+func (p namedProvider) Fetch(ctx context.Context, cep string) (Response, error) {
+	resp, err := p.CEPProvider.Fetch(ctx, cep)
+	resp.APIName = p.name
+	return resp, err
+}
+
+// URL returns the wrapped provider's URL for cep, if it exposes one
+func (p namedProvider) URL(cep string) string {
+	if up, ok := p.CEPProvider.(URLProvider); ok {
+		return up.URL(cep)
+	}
+	return ""
+}
+
+// Unwrap returns the wrapped provider
+func (p namedProvider) Unwrap() CEPProvider { return p.CEPProvider }
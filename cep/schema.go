@@ -0,0 +1,155 @@
+package cep
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// schemaFiles holds the JSON Schema of each provider's success response,
+// named after the lowercased provider name
+//
+//go:embed schemas/*.json
+var schemaFiles embed.FS
+
+// schema is the subset of JSON Schema used to describe provider responses:
+// type, required, properties, items and anyOf
+type schema struct {
+	Type       schemaType         `json:"type"`
+	Required   []string           `json:"required"`
+	Properties map[string]*schema `json:"properties"`
+	Items      *schema            `json:"items"`
+	AnyOf      []*schema          `json:"anyOf"`
+}
+
+// schemaType is the "type" keyword, which may be a single name or a list
+type schemaType []string
+
+func (t *schemaType) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*t = schemaType{name}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(t))
+}
+
+// schemas maps a lowercased provider name to its response schema
+var schemas = loadSchemas()
+
+// loadSchemas parses every embedded schema. They ship with the binary, so
+// a broken one is a programming error.
+func loadSchemas() map[string]*schema {
+	entries, err := schemaFiles.ReadDir("schemas")
+	if err != nil {
+		panic(err)
+	}
+	m := make(map[string]*schema, len(entries))
+	for _, e := range entries {
+		data, err := schemaFiles.ReadFile("schemas/" + e.Name())
+		if err != nil {
+			panic(err)
+		}
+		var s schema
+		if err := json.Unmarshal(data, &s); err != nil {
+			panic(fmt.Sprintf("schema %s: %v", e.Name(), err))
+		}
+		m[strings.TrimSuffix(e.Name(), ".json")] = &s
+	}
+	return m
+}
+
+// schemaFor returns the response schema of the named provider, or nil for
+// providers without one. Other endpoints use their own file name.
+func schemaFor(provider string) *schema {
+	return schemas[strings.ToLower(provider)]
+}
+
+// validate checks v, as decoded by encoding/json into an interface{},
+// against s. path locates v in the document for error messages.
+func (s *schema) validate(v interface{}, path string) error {
+	if len(s.Type) > 0 && !s.Type.matches(v) {
+		return fmt.Errorf("%s: esperado %s, recebido %s", path, strings.Join(s.Type, " ou "), jsonType(v))
+	}
+
+	if obj, ok := v.(map[string]interface{}); ok {
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				return fmt.Errorf("%s: campo obrigatório %q ausente", path, name)
+			}
+		}
+		// Sorted so the reported error is deterministic
+		names := make([]string, 0, len(s.Properties))
+		for name := range s.Properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if fv, ok := obj[name]; ok {
+				if err := s.Properties[name].validate(fv, path+"."+name); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if arr, ok := v.([]interface{}); ok && s.Items != nil {
+		for i, item := range arr {
+			if err := s.Items.validate(item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(s.AnyOf) > 0 {
+		var first error
+		for _, alt := range s.AnyOf {
+			err := alt.validate(v, path)
+			if err == nil {
+				return nil
+			}
+			if first == nil {
+				first = err
+			}
+		}
+		return first
+	}
+	return nil
+}
+
+// matches reports whether v has one of the types in t
+func (t schemaType) matches(v interface{}) bool {
+	got := jsonType(v)
+	for _, want := range t {
+		if want == got {
+			return true
+		}
+		if want == "integer" && got == "number" {
+			if f := v.(float64); f == float64(int64(f)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// jsonType returns the JSON Schema type name of v
+func jsonType(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
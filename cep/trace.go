@@ -0,0 +1,83 @@
+package cep
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"time"
+)
+
+// phases records when each phase of a request started and finished, using
+// an httptrace.ClientTrace. Hooks may run on other goroutines, hence the lock.
+type phases struct {
+	mu                        sync.Mutex
+	start                     time.Time
+	dnsStart, dnsDone         time.Time
+	connectStart, connectDone time.Time
+	tlsStart, tlsDone         time.Time
+	firstByte                 time.Time
+	reused                    bool
+}
+
+// withTrace returns a copy of ctx that records the phases of the request
+// made with it
+func withTrace(ctx context.Context) (context.Context, *phases) {
+	p := &phases{start: time.Now()}
+	// With several connection attempts (e.g. IPv6 then IPv4) keep the
+	// earliest start and the latest end
+	first := func(t *time.Time) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if t.IsZero() {
+			*t = time.Now()
+		}
+	}
+	last := func(t *time.Time) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		*t = time.Now()
+	}
+	trace := &httptrace.ClientTrace{
+		DNSStart:             func(httptrace.DNSStartInfo) { first(&p.dnsStart) },
+		DNSDone:              func(httptrace.DNSDoneInfo) { last(&p.dnsDone) },
+		ConnectStart:         func(string, string) { first(&p.connectStart) },
+		ConnectDone:          func(string, string, error) { last(&p.connectDone) },
+		TLSHandshakeStart:    func() { first(&p.tlsStart) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { last(&p.tlsDone) },
+		GotFirstResponseByte: func() { first(&p.firstByte) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			p.mu.Lock()
+			p.reused = info.Reused
+			p.mu.Unlock()
+		},
+	}
+	return httptrace.WithClientTrace(ctx, trace), p
+}
+
+// String renders the duration of every phase that happened, e.g.
+// "DNS 2ms, conexão 15ms, TLS 40ms, primeiro byte 120ms"
+func (p *phases) String() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var parts []string
+	add := func(label string, from, to time.Time) {
+		if !from.IsZero() && !to.IsZero() {
+			parts = append(parts, fmt.Sprintf("%s %s", label, to.Sub(from).Round(time.Microsecond)))
+		}
+	}
+	add("DNS", p.dnsStart, p.dnsDone)
+	add("conexão", p.connectStart, p.connectDone)
+	add("TLS", p.tlsStart, p.tlsDone)
+	add("primeiro byte", p.start, p.firstByte)
+	if p.reused {
+		parts = append(parts, "conexão reutilizada")
+	}
+	if len(parts) == 0 {
+		return "sem dados"
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,100 @@
+package cep
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Tuned wraps a provider with its own timeout and retry count, which replace
+// ProviderTimeout and Retries for its requests, and extra headers sent with them
+type Tuned struct {
+	CEPProvider
+	Timeout time.Duration // Bounds each lookup when positive; zero means no bound of its own
+	Retries int           // Extra attempts after a transient failure
+	Header  http.Header   // Static headers, such as an API key, added to each request
+}
+
+// URL returns the wrapped provider's URL for cep, if it exposes one
+func (t Tuned) URL(cep string) string {
+	if up, ok := t.CEPProvider.(URLProvider); ok {
+		return up.URL(cep)
+	}
+	return ""
+}
+
+// Fetch queries the wrapped provider with t's retry count and headers
+func (t Tuned) Fetch(ctx context.Context, cep string) (Response, error) {
+	ctx = context.WithValue(ctx, retriesKey{}, t.Retries)
+	if len(t.Header) > 0 {
+		ctx = context.WithValue(ctx, headerKey{}, t.Header)
+	}
+	return t.CEPProvider.Fetch(ctx, cep)
+}
+
+// Unwrap returns the wrapped provider
+func (t Tuned) Unwrap() CEPProvider { return t.CEPProvider }
+
+// retriesKey is the context key of a Tuned provider's retry count
+type retriesKey struct{}
+
+// retries returns the retry count for requests made with ctx
+func retries(ctx context.Context) int {
+	if n, ok := ctx.Value(retriesKey{}).(int); ok {
+		return n
+	}
+	return Retries
+}
+
+// headerKey is the context key of a Tuned provider's extra headers
+type headerKey struct{}
+
+// setHeaders adds the extra headers of ctx to req, replacing the defaults
+// with the same name
+func setHeaders(ctx context.Context, req *http.Request) {
+	h, _ := ctx.Value(headerKey{}).(http.Header)
+	for name, values := range h {
+		req.Header.Del(name)
+		for _, v := range values {
+			req.Header.Add(name, v)
+		}
+	}
+}
+
+// maskedHeaders lists the extra headers of ctx for the log, with their
+// values hidden since they usually carry credentials
+func maskedHeaders(ctx context.Context) string {
+	h, _ := ctx.Value(headerKey{}).(http.Header)
+	list := make([]string, 0, len(h))
+	for name := range h {
+		list = append(list, name+": "+mask(h.Get(name)))
+	}
+	sort.Strings(list)
+	return strings.Join(list, ", ")
+}
+
+// mask hides v, keeping only an authorization scheme such as "Bearer" so the
+// log still tells which kind of credential was sent
+func mask(v string) string {
+	if scheme, _, ok := strings.Cut(v, " "); ok {
+		return scheme + " ****"
+	}
+	return "****"
+}
+
+// providerTimeout returns the timeout of p, looking through wrappers for a
+// Tuned provider and falling back to ProviderTimeout
+func providerTimeout(p CEPProvider) time.Duration {
+	for {
+		switch w := p.(type) {
+		case Tuned:
+			return w.Timeout
+		case interface{ Unwrap() CEPProvider }:
+			p = w.Unwrap()
+		default:
+			return ProviderTimeout
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package cep
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+// ViaCEP represents the structure returned by ViaCEP API
+type ViaCEP struct {
+	Cep         string `json:"cep"`
+	Logradouro  string `json:"logradouro"`
+	Complemento string `json:"complemento"`
+	Bairro      string `json:"bairro"`
+	Localidade  string `json:"localidade"`
+	Uf          string `json:"uf"`
+	Ibge        string `json:"ibge"`
+	Gia         string `json:"gia"`
+	Ddd         string `json:"ddd"`
+	Siafi       string `json:"siafi"`
+	Erro        bool   `json:"erro"` // Set instead of the fields above for unknown CEPs
+}
+
+// ViaCEPProvider fetches CEP data from ViaCEP
+type ViaCEPProvider struct {
+	Client  *http.Client
+	BaseURL string // Defaults to ViaCEPBaseURL when empty
+}
+
+// ViaCEPBaseURL is the public endpoint used when BaseURL is empty
+const ViaCEPBaseURL = "https://viacep.com.br/ws"
+
+func (p ViaCEPProvider) baseURL() string {
+	if p.BaseURL != "" {
+		return p.BaseURL
+	}
+	return ViaCEPBaseURL
+}
+
+// Name returns the provider name used in the output
+func (ViaCEPProvider) Name() string { return "ViaCEP" }
+
+// URL returns the address queried for cep
+func (p ViaCEPProvider) URL(cep string) string {
+	return fmt.Sprintf("%s/%s/json/", p.baseURL(), cep)
+}
+
+// Fetch queries ViaCEP for the given CEP
+func (p ViaCEPProvider) Fetch(ctx context.Context, cep string) (Response, error) {
+	url := p.URL(cep)
+
+	var data ViaCEP
+	if err := getJSON(ctx, p.Client, p.Name(), url, schemaFor(p.Name()), &data); err != nil {
+		return Response{APIName: p.Name()}, err
+	}
+	// ViaCEP answers unknown CEPs with 200 and {"erro": true}
+	if data.Erro {
+		return Response{APIName: p.Name()}, notFound(p.Name())
+	}
+	return Response{APIName: p.Name(), Address: fromViaCEP(data)}, nil
+}
+
+// fromViaCEP maps a ViaCEP response into an Address
+func fromViaCEP(data ViaCEP) Address {
+	return Address{
+		CEP:          data.Cep,
+		Street:       data.Logradouro,
+		Neighborhood: data.Bairro,
+		City:         data.Localidade,
+		State:        data.Uf,
+		IBGE:         data.Ibge,
+		DDD:          data.Ddd,
+		Source:       "ViaCEP",
+		Service:      "viacep",
+	}
+}
+
+// ErrInvalidSearch is returned by Search when the state isn't a two-letter
+// code or the city or street is shorter than 3 characters, which ViaCEP
+// rejects
+var ErrInvalidSearch = errors.New("busca inválida: informe a UF com 2 letras e cidade e rua com ao menos 3 caracteres")
+
+// SearchURL returns the address of ViaCEP's search by state, city and street
+func (p ViaCEPProvider) SearchURL(uf, city, street string) string {
+	return fmt.Sprintf("%s/%s/%s/%s/json/", p.baseURL(),
+		url.PathEscape(strings.ToUpper(uf)), url.PathEscape(city), url.PathEscape(street))
+}
+
+// Search resolves an address to candidate CEPs using ViaCEP's search by
+// state, city and street. The street may be partial; ViaCEP returns at most
+// 50 matches and an empty list when nothing matches.
+func (p ViaCEPProvider) Search(ctx context.Context, uf, city, street string) ([]Address, error) {
+	uf, city, street = strings.TrimSpace(uf), strings.TrimSpace(city), strings.TrimSpace(street)
+	if len(uf) != 2 || utf8.RuneCountInString(city) < 3 || utf8.RuneCountInString(street) < 3 {
+		return nil, ErrInvalidSearch
+	}
+
+	var data []ViaCEP
+	if err := getJSON(ctx, p.Client, p.Name(), p.SearchURL(uf, city, street), schemas["viacep-search"], &data); err != nil {
+		return nil, err
+	}
+	addrs := make([]Address, len(data))
+	for i, d := range data {
+		addrs[i] = fromViaCEP(d)
+	}
+	return addrs, nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// ANSI escape sequences used by the text output
+const (
+	ansiReset  = "\x1b[0m"
+	ansiRed    = "\x1b[31m"
+	ansiGreen  = "\x1b[32m"
+	ansiYellow = "\x1b[33m"
+)
+
+// palette colors the text output. The zero value leaves text untouched.
+type palette struct {
+	enabled bool
+}
+
+func (p palette) paint(code, s string) string {
+	if !p.enabled {
+		return s
+	}
+	return code + s + ansiReset
+}
+
+// winner highlights the API that won the race
+func (p palette) winner(s string) string { return p.paint(ansiGreen, s) }
+
+// slow highlights the slowest API of the comparison
+func (p palette) slow(s string) string { return p.paint(ansiYellow, s) }
+
+// err highlights error messages
+func (p palette) err(s string) string { return p.paint(ansiRed, s) }
+
+// newPalette resolves -color for out: "always" and "never" force colors on
+// and off, while "auto" enables them only when out is a terminal and the
+// NO_COLOR environment variable is unset
+func newPalette(mode string, out *os.File) (palette, error) {
+	switch mode {
+	case "always":
+		return palette{enabled: true}, nil
+	case "never":
+		return palette{}, nil
+	case "auto":
+		return palette{enabled: os.Getenv("NO_COLOR") == "" && isTerminal(out)}, nil
+	}
+	return palette{}, fmt.Errorf("valor inválido para -color: %q (use auto, always ou never)", mode)
+}
+
+// isTerminal reports whether f is a character device, which is the case for
+// an interactive terminal but not for pipes and files
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/prodbygus/golang-multithreading/cep"
+)
+
+// compareFields are the address fields shown side by side by -compare-only
+var compareFields = []struct {
+	label string
+	value func(cep.Address) string
+}{
+	{"CEP", func(a cep.Address) string { return a.CEP }},
+	{"Rua", func(a cep.Address) string { return a.Street }},
+	{"Bairro", func(a cep.Address) string { return a.Neighborhood }},
+	{"Cidade", func(a cep.Address) string { return a.City }},
+	{"Estado", func(a cep.Address) string { return a.State }},
+	{"IBGE", func(a cep.Address) string { return a.IBGE }},
+	{"DDD", func(a cep.Address) string { return a.DDD }},
+	{"Coordenadas", func(a cep.Address) string {
+		if !a.HasCoordinates() {
+			return ""
+		}
+		return formatCoordinate(a.Latitude) + ", " + formatCoordinate(a.Longitude)
+	}},
+}
+
+// mismatchedFields returns the labels of the fields the answers disagree on.
+// Values are compared exactly, but a provider that leaves a field empty
+// doesn't report it rather than disagree.
+func mismatchedFields(answers []cep.Response) []string {
+	var mismatches []string
+	for _, field := range compareFields {
+		seen := ""
+		for _, a := range answers {
+			v := field.value(a.Address)
+			if v == "" {
+				continue
+			}
+			if seen == "" {
+				seen = v
+			} else if v != seen {
+				mismatches = append(mismatches, field.label)
+				break
+			}
+		}
+	}
+	return mismatches
+}
+
+// compareFormatter prints the answer of every provider side by side and
+// marks the fields where they disagree, without any timing
+type compareFormatter struct {
+	count int
+	color palette
+}
+
+func (f *compareFormatter) Begin(io.Writer) {}
+
+func (f *compareFormatter) End(io.Writer) {}
+
+func (f *compareFormatter) Format(w io.Writer, r lookupResult) {
+	if f.count > 0 {
+		fmt.Fprintln(w)
+	}
+	f.count++
+
+	if r.err != nil {
+		fmt.Fprintln(w, f.color.err(fmt.Sprintf("%s: %s", r.input, shortError(r.err))))
+		return
+	}
+	fmt.Fprintf(w, "=== CEP %s ===\n", r.displayCEP())
+
+	// Columns in a stable order, whichever provider answered first
+	answers := append([]cep.Response(nil), r.summary.answers...)
+	sort.Slice(answers, func(i, j int) bool { return answers[i].APIName < answers[j].APIName })
+
+	mismatched := make(map[string]bool, len(r.mismatches))
+	for _, label := range r.mismatches {
+		mismatched[label] = true
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprint(tw, "\tCampo")
+	for _, a := range answers {
+		fmt.Fprintf(tw, "\t%s", a.APIName)
+	}
+	fmt.Fprintln(tw)
+	for _, field := range compareFields {
+		mark := ""
+		if mismatched[field.label] {
+			mark = "≠"
+		}
+		fmt.Fprintf(tw, "%s\t%s", mark, field.label)
+		for _, a := range answers {
+			fmt.Fprintf(tw, "\t%s", orDash(field.value(a.Address)))
+		}
+		fmt.Fprintln(tw)
+	}
+	tw.Flush()
+
+	for _, failure := range r.summary.failed {
+		fmt.Fprintln(w, f.color.err(fmt.Sprintf("%s: %s", failure.APIName, failureReason(failure))))
+	}
+	switch {
+	case len(answers) < 2:
+		fmt.Fprintln(w, "Apenas uma API respondeu; nada a comparar.")
+	case len(r.mismatches) > 0:
+		fmt.Fprintln(w, f.color.err(fmt.Sprintf("Divergências: %d campo(s) marcados com ≠", len(r.mismatches))))
+	default:
+		fmt.Fprintln(w, "Todas as APIs concordam.")
+	}
+}
+
+// orDash shows "-" for a field the provider didn't report
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
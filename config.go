@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/prodbygus/golang-multithreading/cep"
+)
+
+// fileConfig is the -config file: settings for each provider, keyed by its
+// lowercased name
+//
+//	{"providers": {"postmon": {"timeout": "300ms", "retries": 0, "enabled": false}}}
+type fileConfig struct {
+	Providers map[string]providerConfig `json:"providers"`
+}
+
+// providerConfig holds the settings of a single provider. Unset fields keep
+// the defaults of the corresponding flags.
+type providerConfig struct {
+	URL     string            `json:"url"`     // Base URL, like -brasilapi-url
+	Timeout duration          `json:"timeout"` // Like -provider-timeout, for this provider only
+	Retries *int              `json:"retries"` // Like -retries, for this provider only
+	Enabled *bool             `json:"enabled"` // false leaves the provider out
+	Headers map[string]string `json:"headers"` // Sent with each request, e.g. an API key
+}
+
+// duration is a time.Duration written as a string such as "300ms"
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duração deve ser um texto como \"300ms\": %s", b)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+// loadConfig reads and validates the config file at path. Unknown fields are
+// rejected so that typos don't go unnoticed.
+func loadConfig(path string) (fileConfig, error) {
+	var cfg fileConfig
+	f, err := os.Open(path)
+	if err != nil {
+		return cfg, err
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return cfg, fmt.Errorf("%s: %v", path, err)
+	}
+
+	providers := make(map[string]providerConfig, len(cfg.Providers))
+	for name, pc := range cfg.Providers {
+		if pc.Timeout < 0 {
+			return cfg, fmt.Errorf("%s: o timeout de %s não pode ser negativo", path, name)
+		}
+		if pc.Retries != nil && *pc.Retries < 0 {
+			return cfg, fmt.Errorf("%s: o número de tentativas de %s não pode ser negativo", path, name)
+		}
+		for header, value := range pc.Headers {
+			if header == "" || strings.ContainsAny(header, " \t\r\n:") || strings.ContainsAny(value, "\r\n") {
+				return cfg, fmt.Errorf("%s: cabeçalho inválido em %s: %q", path, name, header)
+			}
+		}
+		providers[strings.ToLower(name)] = pc
+	}
+	cfg.Providers = providers
+	return cfg, nil
+}
+
+// baseURL returns the base URL of the named provider: value when its flag
+// was set on the command line, else the configured URL, else value
+func (c fileConfig) baseURL(name string, flagSet bool, value string) string {
+	if u := c.Providers[name].URL; u != "" && !flagSet {
+		return u
+	}
+	return value
+}
+
+// applyConfig drops the disabled providers and wraps the ones with their own
+// timeout, retries or headers in a cep.Tuned. Flags set on the command line win:
+// -providers decides which providers run, and -provider-timeout and
+// -retries apply to all of them.
+func applyConfig(providers []cep.CEPProvider, cfg fileConfig, set map[string]bool) ([]cep.CEPProvider, error) {
+	for name := range cfg.Providers {
+		if _, ok := findProvider(providers, name); !ok {
+			return nil, fmt.Errorf("API desconhecida no arquivo de configuração: %q (disponíveis: %s)", name, providerList(providers))
+		}
+	}
+
+	var kept []cep.CEPProvider
+	for _, p := range providers {
+		pc := cfg.Providers[strings.ToLower(p.Name())]
+		if pc.Enabled != nil && !*pc.Enabled && !set["providers"] {
+			continue
+		}
+
+		tuned := cep.Tuned{CEPProvider: p, Timeout: cep.ProviderTimeout, Retries: cep.Retries}
+		custom := false
+		if pc.Timeout > 0 && !set["provider-timeout"] {
+			tuned.Timeout, custom = time.Duration(pc.Timeout), true
+		}
+		if pc.Retries != nil && !set["retries"] {
+			tuned.Retries, custom = *pc.Retries, true
+		}
+		if len(pc.Headers) > 0 {
+			tuned.Header = make(http.Header, len(pc.Headers))
+			for header, value := range pc.Headers {
+				tuned.Header.Set(header, value)
+			}
+			custom = true
+		}
+		if custom {
+			p = tuned
+		}
+		kept = append(kept, p)
+	}
+	if len(kept) == 0 {
+		return nil, fmt.Errorf("todas as APIs estão desabilitadas no arquivo de configuração")
+	}
+	return kept, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// requestBudget counts the lookups served by -serve and signals Done once
+// the -count limit is reached, keeping every duration for the summary
+// printed on exit. It is safe for concurrent use.
+type requestBudget struct {
+	limit int
+	done  chan struct{}
+
+	mu        sync.Mutex
+	durations []time.Duration
+	failures  int
+}
+
+// newRequestBudget returns a budget of limit lookups, which must be positive
+func newRequestBudget(limit int) *requestBudget {
+	return &requestBudget{limit: limit, done: make(chan struct{})}
+}
+
+// Add records a served lookup and closes Done when it is the last one. Lookups
+// finishing after the limit, while the server drains, are still counted.
+func (b *requestBudget) Add(d time.Duration, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.durations = append(b.durations, d)
+	if err != nil {
+		b.failures++
+	}
+	if len(b.durations) == b.limit {
+		close(b.done)
+	}
+}
+
+// Done is closed once limit lookups were served
+func (b *requestBudget) Done() <-chan struct{} {
+	return b.done
+}
+
+// Summary writes the number of lookups served and their latency statistics
+func (b *requestBudget) Summary(w io.Writer) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	fmt.Fprintf(w, "Requisições atendidas: %d (%d com erro)\n", len(b.durations), b.failures)
+	if len(b.durations) == 0 {
+		return
+	}
+	fastest, slowest, mean := summarize(b.durations)
+	fmt.Fprintf(w, "Latência: mín %.3fs, máx %.3fs, média %.3fs, p50 %.3fs, p95 %.3fs, p99 %.3fs\n",
+		fastest.Seconds(), slowest.Seconds(), mean.Seconds(),
+		percentile(b.durations, 50).Seconds(), percentile(b.durations, 95).Seconds(),
+		percentile(b.durations, 99).Seconds())
+}
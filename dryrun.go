@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/prodbygus/golang-multithreading/cep"
+)
+
+// dryRun prints the URL each provider would request for every CEP in raws,
+// along with the proxy chosen from the environment, without touching the
+// network. It returns the exit code of the first invalid CEP, if any.
+func dryRun(w io.Writer, providers []cep.CEPProvider, raws <-chan string) int {
+	code := exitOK
+	for raw := range raws {
+		normalized, err := cep.Normalize(raw)
+		if err != nil {
+			fmt.Fprintf(w, "%s: %v\n", raw, err)
+			if code == exitOK {
+				code = exitCodeFor(err)
+			}
+			continue
+		}
+
+		fmt.Fprintf(w, "CEP: %s (entrada: %s)\n", normalized, raw)
+		for _, p := range providers {
+			up, ok := p.(cep.URLProvider)
+			if !ok {
+				fmt.Fprintf(w, "  %s: URL não disponível\n", p.Name())
+				continue
+			}
+			url := up.URL(normalized)
+			fmt.Fprintf(w, "  %s: GET %s%s\n", p.Name(), url, proxyNote(url))
+		}
+	}
+	return code
+}
+
+// proxyNote describes the proxy that http.ProxyFromEnvironment would pick
+// for url, or returns an empty string when the request goes direct
+func proxyNote(url string) string {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Sprintf(" (URL inválida: %v)", err)
+	}
+	proxy, err := http.ProxyFromEnvironment(req)
+	switch {
+	case err != nil:
+		return fmt.Sprintf(" (proxy inválido: %v)", err)
+	case proxy != nil:
+		return " (via proxy " + proxy.Redacted() + ")"
+	}
+	return ""
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/prodbygus/golang-multithreading/cep"
+)
+
+// Exit codes of the CLI. In a batch the first failing CEP decides the code.
+const (
+	exitOK           = 0   // Every lookup succeeded
+	exitFailure      = 1   // Every provider failed or the CEP wasn't found
+	exitInconsistent = 2   // Providers disagree on the city (-check-city)
+	exitInvalidCEP   = 3   // A CEP doesn't have 8 digits
+	exitTimeout      = 4   // No provider answered within -timeout
+	exitUsage        = 64  // Invalid flags or arguments
+	exitInterrupted  = 130 // Canceled with Ctrl+C (SIGINT)
+)
+
+// exitCodeFor returns the exit code of a lookup that ended with err
+func exitCodeFor(err error) int {
+	var timeout timeoutError
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, cep.ErrInvalidCEP):
+		return exitInvalidCEP
+	case errors.As(err, &timeout), errors.Is(err, errDeadline):
+		return exitTimeout
+	case errors.Is(err, errCanceled):
+		return exitInterrupted
+	default:
+		return exitFailure
+	}
+}
+
+// usageError prints msg followed by the usage and exits with exitUsage
+func usageError(msg string) {
+	fmt.Fprintln(flag.CommandLine.Output(), msg)
+	flag.Usage()
+	os.Exit(exitUsage)
+}
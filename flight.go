@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// flight is a lookup in progress, shared by every caller asking for its key
+type flight struct {
+	done    chan struct{} // Closed once res is set
+	res     lookupResult
+	waiters int // Callers still waiting; the lookup is canceled at zero
+	cancel  context.CancelFunc
+}
+
+// flightGroup deduplicates concurrent lookups, in the manner of
+// golang.org/x/sync/singleflight: callers asking for a key already in flight
+// wait for that lookup instead of starting another. It is safe for
+// concurrent use.
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{flights: make(map[string]*flight)}
+}
+
+// do returns the result of fn for key, running it only if no call for key is
+// in flight; shared reports whether the result came from another caller's
+// call. fn gets a context carrying the first caller's values, which is
+// canceled only once every waiting caller's ctx is done, so one client
+// going away doesn't fail the others. A caller whose ctx is done before the
+// result is ready gets (zero, false) and ctx.Err().
+func (g *flightGroup) do(ctx context.Context, key string, fn func(context.Context) lookupResult) (res lookupResult, shared bool, err error) {
+	g.mu.Lock()
+	f, shared := g.flights[key]
+	if !shared {
+		flightCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		f = &flight{done: make(chan struct{}), cancel: cancel}
+		g.flights[key] = f
+		go g.run(flightCtx, key, f, fn)
+	}
+	f.waiters++
+	g.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return f.res, shared, nil
+	case <-ctx.Done():
+		g.mu.Lock()
+		f.waiters--
+		if f.waiters == 0 {
+			// Nobody is left to read the result; late callers start over
+			f.cancel()
+			g.forget(key, f)
+		}
+		g.mu.Unlock()
+		return lookupResult{}, false, ctx.Err()
+	}
+}
+
+// run calls fn for f and removes f from the group once it returns, so later
+// callers start a fresh lookup
+func (g *flightGroup) run(ctx context.Context, key string, f *flight, fn func(context.Context) lookupResult) {
+	defer f.cancel()
+	f.res = fn(ctx)
+
+	g.mu.Lock()
+	g.forget(key, f)
+	g.mu.Unlock()
+	close(f.done)
+}
+
+// forget removes f from the group unless a newer flight took its key. g.mu
+// must be held.
+func (g *flightGroup) forget(key string, f *flight) {
+	if g.flights[key] == f {
+		delete(g.flights, key)
+	}
+}
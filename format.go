@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/prodbygus/golang-multithreading/cep"
+)
+
+// OutputFormatter renders lookup results. Begin is called once before the
+// first result and End once after the last, so formatters that need the
+// whole batch (such as an aligned table) can buffer until then. Calls are
+// never concurrent.
+type OutputFormatter interface {
+	Begin(w io.Writer)
+	Format(w io.Writer, r lookupResult)
+	End(w io.Writer)
+}
+
+// formatNames lists the values accepted by -format
+var formatNames = []string{"text", "json", "csv", "table", "quiet", "geojson"}
+
+// newFormatter returns the formatter selected by -format. Only the text
+// format is colored, and only it prints the timing comparison apart, to
+// timing.
+func newFormatter(name string, color palette, timing io.Writer) (OutputFormatter, error) {
+	switch name {
+	case "text":
+		return &textFormatter{color: color, timing: timing}, nil
+	case "json":
+		return &jsonFormatter{}, nil
+	case "csv":
+		return &csvFormatter{}, nil
+	case "table":
+		return &tableFormatter{}, nil
+	case "quiet":
+		return &quietFormatter{errw: os.Stderr}, nil
+	case "geojson":
+		return &geojsonFormatter{errw: os.Stderr}, nil
+	}
+	return nil, fmt.Errorf("formato desconhecido: %q", name)
+}
+
+// textFormatter prints the human-readable Portuguese output, with a
+// separator between CEPs. The timing comparison and total time go to
+// timing instead, when set.
+type textFormatter struct {
+	count  int
+	color  palette
+	timing io.Writer
+}
+
+// timingWriter returns where the timing of a lookup written to w goes
+func (f *textFormatter) timingWriter(w io.Writer) io.Writer {
+	if f.timing != nil {
+		return f.timing
+	}
+	return w
+}
+
+func (f *textFormatter) Begin(io.Writer) {}
+
+func (f *textFormatter) End(io.Writer) {}
+
+func (f *textFormatter) Format(w io.Writer, r lookupResult) {
+	if f.count > 0 {
+		fmt.Fprintln(w, "\n----------------------------------------")
+	}
+	f.count++
+
+	f.format(w, r)
+	if r.elapsed > 0 {
+		fmt.Fprintf(f.timingWriter(w), "\nTempo total: %.3fs\n", r.elapsed.Seconds())
+	}
+}
+
+// format prints everything about r but the total elapsed time
+func (f *textFormatter) format(w io.Writer, r lookupResult) {
+	if r.cep == "" {
+		fmt.Fprintln(w, f.color.err(fmt.Sprintf("%s: %v", r.input, r.err)))
+		return
+	}
+	fmt.Fprintf(w, "Buscando informações para o CEP: %s\n", r.displayCEP())
+
+	if r.err != nil {
+		var allFailed *cep.AllFailedError
+		var timeout timeoutError
+		switch {
+		case errors.As(r.err, &allFailed):
+			fmt.Fprintln(w, f.color.err("Erro: todas as APIs falharam"))
+			for _, failure := range allFailed.Failures {
+				fmt.Fprintf(w, "  - %s: %v\n", failure.APIName, failure.Error)
+			}
+		case errors.As(r.err, &timeout):
+			fmt.Fprintln(w, f.color.err(fmt.Sprintf("Erro: Timeout após %s", timeout.timeout)))
+		default:
+			fmt.Fprintln(w, f.color.err(fmt.Sprintf("Erro: %v", r.err)))
+		}
+		return
+	}
+
+	if r.cached() {
+		fmt.Fprintf(w, "Resposta obtida do cache (origem: %s)\n\n", r.winner.Address.Source)
+		printAddress(w, r.winner.Address)
+		return
+	}
+
+	fmt.Fprintf(w, "Resposta mais rápida da API: %s (%.3fs)\n", f.color.winner(r.winner.APIName), r.winner.Duration.Seconds())
+	if len(r.agreed) > 0 {
+		fmt.Fprintf(w, "Confirmada por: %s\n", strings.Join(r.agreed, ", "))
+	}
+	fmt.Fprintln(w)
+	printAddress(w, r.winner.Address)
+
+	if r.summary == nil {
+		return
+	}
+	printComparison(f.timingWriter(w), f.color, r.summary.timings, r.summary.failed, r.summary.canceled)
+	if r.diff {
+		printDifferences(w, r.summary.answers)
+	}
+	if r.explain {
+		fmt.Fprintf(w, "\n%s\n", explainRace(r.winner, r.summary))
+	}
+}
+
+// quietFormatter prints only "street, city - state" for scripts, sending a
+// short message to errw instead when the lookup fails
+type quietFormatter struct {
+	errw io.Writer
+}
+
+func (f *quietFormatter) Begin(io.Writer) {}
+
+func (f *quietFormatter) End(io.Writer) {}
+
+func (f *quietFormatter) Format(w io.Writer, r lookupResult) {
+	if r.err != nil {
+		fmt.Fprintf(f.errw, "%s: %s\n", r.input, shortError(r.err))
+		return
+	}
+	fmt.Fprintln(w, quietLine(r.winner.Address))
+}
+
+// shortError summarizes err in a few words, without the per-provider details
+func shortError(err error) string {
+	var timeout timeoutError
+	switch {
+	case errors.As(err, &timeout):
+		return fmt.Sprintf("timeout após %s", timeout.timeout)
+	case errors.Is(err, cep.ErrCEPNotFound):
+		return "CEP não encontrado"
+	case errors.As(err, new(*cep.AllFailedError)):
+		return "todas as APIs falharam"
+	}
+	return err.Error()
+}
+
+// quietLine formats addr on one line, leaving out the street when the CEP
+// covers a whole city
+func quietLine(addr cep.Address) string {
+	place := fmt.Sprintf("%s - %s", addr.City, addr.State)
+	if addr.Street == "" {
+		return place
+	}
+	return addr.Street + ", " + place
+}
+
+// printComparison prints the comparative timing of the providers that
+// answered, fastest first, followed by the ones that failed and the ones
+// canceled after losing
+func printComparison(w io.Writer, color palette, timingResults map[string]time.Duration, failed []cep.Response, canceled []string) {
+	fmt.Fprintln(w, "\n=== Comparativo de Tempo de Resposta ===")
+
+	// Check if we have at least two results
+	if len(timingResults) > 1 {
+		fastest, slowest := fastestAndSlowest(timingResults)
+		fastestTime, slowestTime := timingResults[fastest], timingResults[slowest]
+
+		// Print results
+		fmt.Fprintf(w, "API mais rápida: %s (%.3fs)\n", color.winner(fastest), fastestTime.Seconds())
+		fmt.Fprintf(w, "API mais lenta: %s (%.3fs)\n", color.slow(slowest), slowestTime.Seconds())
+		fmt.Fprintf(w, "Diferença: %.3fs\n", slowestTime.Seconds()-fastestTime.Seconds())
+
+		for _, api := range byDuration(timingResults) {
+			fmt.Fprintf(w, "%s: %.3fs\n", api, timingResults[api].Seconds())
+		}
+	} else {
+		// A single answer still has a latency worth reporting
+		for api, d := range timingResults {
+			fmt.Fprintf(w, "Apenas a %s respondeu (%.3fs); não há outra resposta para comparar.\n", color.winner(api), d.Seconds())
+		}
+	}
+	for _, f := range failed {
+		fmt.Fprintln(w, color.err(fmt.Sprintf("%s: %s", f.APIName, failureReason(f))))
+	}
+	for _, api := range canceled {
+		fmt.Fprintf(w, "%s: cancelada (perdeu a corrida)\n", api)
+	}
+}
+
+// failureReason describes why the provider of f failed, after how long
+func failureReason(f cep.Response) string {
+	if errors.Is(f.Error, context.DeadlineExceeded) {
+		return fmt.Sprintf("excedeu o tempo limite após %.3fs", f.Duration.Seconds())
+	}
+	return fmt.Sprintf("falhou após %.3fs (%v)", f.Duration.Seconds(), f.Error)
+}
+
+// explainRace narrates the outcome of every provider in a race, e.g.
+// "BrasilAPI venceu por 142ms; ViaCEP respondeu em 310ms mas perdeu a corrida."
+func explainRace(winner cep.Response, s *raceSummary) string {
+	var parts []string
+
+	others := byDuration(s.timings)[1:]
+	if len(others) > 0 {
+		margin := s.timings[others[0]] - winner.Duration
+		parts = append(parts, fmt.Sprintf("%s venceu por %s", winner.APIName, roundMS(margin)))
+	} else {
+		parts = append(parts, fmt.Sprintf("%s venceu em %s", winner.APIName, roundMS(winner.Duration)))
+	}
+	for _, api := range others {
+		parts = append(parts, fmt.Sprintf("%s respondeu em %s mas perdeu a corrida", api, roundMS(s.timings[api])))
+	}
+	for _, f := range s.failed {
+		if errors.Is(f.Error, context.DeadlineExceeded) {
+			parts = append(parts, fmt.Sprintf("%s excedeu o tempo limite após %s", f.APIName, roundMS(f.Duration)))
+		} else {
+			parts = append(parts, fmt.Sprintf("%s falhou após %s (%v)", f.APIName, roundMS(f.Duration), f.Error))
+		}
+	}
+	for _, api := range s.canceled {
+		parts = append(parts, fmt.Sprintf("%s foi cancelada antes de responder, ao perder a corrida", api))
+	}
+	return strings.Join(parts, "; ") + "."
+}
+
+// roundMS renders d rounded to whole milliseconds
+func roundMS(d time.Duration) time.Duration {
+	return d.Round(time.Millisecond)
+}
+
+// printAddress prints addr in the human-readable format
+func printAddress(w io.Writer, addr cep.Address) {
+	fmt.Fprintf(w, "CEP: %s\nEstado: %s\nCidade: %s\nBairro: %s\nRua: %s\nIBGE: %s\nDDD: %s\n",
+		addr.CEP, addr.State, addr.City, addr.Neighborhood, addr.Street,
+		orMissing(addr.IBGE), orMissing(addr.DDD))
+	if addr.HasCoordinates() {
+		fmt.Fprintf(w, "Coordenadas: %s, %s\n", formatCoordinate(addr.Latitude), formatCoordinate(addr.Longitude))
+	}
+	fmt.Fprintf(w, "Serviço: %s\n", addr.Service)
+}
+
+// orMissing labels fields that the winning provider doesn't report
+func orMissing(s string) string {
+	if s == "" {
+		return "(não informado pela API)"
+	}
+	return s
+}
+
+// fieldLabels are the output labels of the fields compared by -diff
+var fieldLabels = map[string]string{
+	"Street":       "Rua",
+	"Neighborhood": "Bairro",
+	"City":         "Cidade",
+	"State":        "Estado",
+}
+
+// printDifferences prints every field where a provider disagrees with the
+// winner, which is the first answer
+func printDifferences(w io.Writer, answers []cep.Response) {
+	fmt.Fprintln(w, "\n=== Diferenças entre as APIs ===")
+	if len(answers) < 2 {
+		fmt.Fprintln(w, "Apenas uma API respondeu; nada a comparar.")
+		return
+	}
+
+	found := false
+	winner := answers[0]
+	for _, other := range answers[1:] {
+		for _, d := range cep.DiffAddresses(winner.Address, other.Address) {
+			found = true
+			fmt.Fprintf(w, "%s difere: %s='%s' vs %s='%s'\n",
+				fieldLabels[d.Field], winner.APIName, d.A, other.APIName, d.B)
+		}
+	}
+	if !found {
+		fmt.Fprintln(w, "Nenhuma diferença encontrada.")
+	}
+}
+
+// csvHeader is the first row written by csvFormatter
+var csvHeader = []string{"cep", "source", "duration_ms", "street", "neighborhood", "city", "state", "ibge", "ddd", "latitude", "longitude", "service", "error"}
+
+// csvFormatter writes one row per CEP, handy for spreadsheets
+type csvFormatter struct {
+	w *csv.Writer
+}
+
+func (f *csvFormatter) Begin(w io.Writer) {
+	f.w = csv.NewWriter(w)
+	f.w.Write(csvHeader)
+	f.w.Flush()
+}
+
+func (f *csvFormatter) Format(_ io.Writer, r lookupResult) {
+	f.w.Write(resultRow(r, func(d time.Duration) string {
+		return strconv.FormatFloat(milliseconds(d), 'f', 3, 64)
+	}))
+	// Flush every row so results stream while the batch is running
+	f.w.Flush()
+}
+
+func (f *csvFormatter) End(io.Writer) {}
+
+// tableFormatter prints every CEP as a row of an aligned table once the
+// whole batch is done
+type tableFormatter struct {
+	tw *tabwriter.Writer
+}
+
+func (f *tableFormatter) Begin(w io.Writer) {
+	f.tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(f.tw, "CEP\tAPI\tTempo\tRua\tBairro\tCidade\tUF\tIBGE\tDDD\tLatitude\tLongitude\tServiço\tErro")
+}
+
+func (f *tableFormatter) Format(_ io.Writer, r lookupResult) {
+	row := resultRow(r, func(d time.Duration) string {
+		return fmt.Sprintf("%.3fs", d.Seconds())
+	})
+	for i, col := range row {
+		if i > 0 {
+			fmt.Fprint(f.tw, "\t")
+		}
+		fmt.Fprint(f.tw, col)
+	}
+	fmt.Fprintln(f.tw)
+}
+
+func (f *tableFormatter) End(io.Writer) {
+	f.tw.Flush()
+}
+
+// resultRow flattens r into the columns shared by the CSV and table
+// formats, using duration to render the winner's latency
+func resultRow(r lookupResult, duration func(time.Duration) string) []string {
+	code := r.displayCEP()
+	if r.err != nil {
+		return []string{code, "", "", "", "", "", "", "", "", "", "", "", r.err.Error()}
+	}
+
+	addr := r.winner.Address
+	elapsed := ""
+	if !r.cached() {
+		elapsed = duration(r.winner.Duration)
+	}
+	lat, lng := "", ""
+	if addr.HasCoordinates() {
+		lat, lng = formatCoordinate(addr.Latitude), formatCoordinate(addr.Longitude)
+	}
+	return []string{code, r.winner.APIName, elapsed, addr.Street, addr.Neighborhood, addr.City, addr.State, addr.IBGE, addr.DDD, lat, lng, addr.Service, ""}
+}
+
+// formatCoordinate writes a latitude or longitude with no more digits than
+// needed
+func formatCoordinate(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// geoFeature is a GeoJSON Feature for a resolved address
+type geoFeature struct {
+	Type       string        `json:"type"`
+	Geometry   *geoPoint     `json:"geometry"` // Null when the provider sent no coordinates
+	Properties geoProperties `json:"properties"`
+}
+
+// geoPoint is a GeoJSON Point, which lists longitude before latitude
+type geoPoint struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"`
+}
+
+// geoProperties are the address fields carried by a geoFeature
+type geoProperties struct {
+	CEP          string `json:"cep"`
+	Street       string `json:"street"`
+	Neighborhood string `json:"neighborhood"`
+	City         string `json:"city"`
+	State        string `json:"state"`
+	IBGE         string `json:"ibge,omitempty"`
+	DDD          string `json:"ddd,omitempty"`
+	Source       string `json:"source"`
+}
+
+// geoCollection is a GeoJSON FeatureCollection
+type geoCollection struct {
+	Type     string       `json:"type"`
+	Features []geoFeature `json:"features"`
+}
+
+// geojsonFormatter collects every resolved address and writes them at the
+// end: a single Feature for one CEP, a FeatureCollection for a batch. Failed
+// lookups can't be represented, so they go to errw.
+type geojsonFormatter struct {
+	errw     io.Writer
+	results  int
+	features []geoFeature
+}
+
+func (f *geojsonFormatter) Begin(io.Writer) {}
+
+func (f *geojsonFormatter) Format(w io.Writer, r lookupResult) {
+	f.results++
+	if r.err != nil {
+		fmt.Fprintf(f.errw, "%s: %s\n", r.input, shortError(r.err))
+		return
+	}
+
+	a := r.winner.Address
+	feature := geoFeature{
+		Type: "Feature",
+		Properties: geoProperties{
+			CEP:          a.CEP,
+			Street:       a.Street,
+			Neighborhood: a.Neighborhood,
+			City:         a.City,
+			State:        a.State,
+			IBGE:         a.IBGE,
+			DDD:          a.DDD,
+			Source:       a.Source,
+		},
+	}
+	if a.HasCoordinates() {
+		feature.Geometry = &geoPoint{Type: "Point", Coordinates: [2]float64{a.Longitude, a.Latitude}}
+	}
+	f.features = append(f.features, feature)
+}
+
+func (f *geojsonFormatter) End(w io.Writer) {
+	enc := json.NewEncoder(w)
+	if f.results == 1 && len(f.features) == 1 {
+		enc.Encode(f.features[0])
+		return
+	}
+	features := f.features
+	if features == nil {
+		features = []geoFeature{}
+	}
+	enc.Encode(geoCollection{Type: "FeatureCollection", Features: features})
+}
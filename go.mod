@@ -1,3 +1,3 @@
 module github.com/prodbygus/golang-multithreading
 
-go 1.20
+go 1.21
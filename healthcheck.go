@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/prodbygus/golang-multithreading/cep"
+)
+
+// healthCEP is the known-good CEP probed by -healthcheck, the São Paulo
+// central post office
+const healthCEP = "01001000"
+
+// providerHealth is the outcome of probing a single provider
+type providerHealth struct {
+	Provider   string  `json:"provider"`
+	Up         bool    `json:"up"`
+	DurationMS float64 `json:"duration_ms"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// runHealthcheck queries every provider for healthCEP at once, regardless of
+// -mode, and prints whether each one is up along with its latency. It
+// returns exitFailure when any provider is down.
+func runHealthcheck(ctx context.Context, w io.Writer, opts lookupOptions, timeout time.Duration, format string) int {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	providers := opts.client.Providers()
+	results := cep.Race(ctx, healthCEP, providers)
+	if opts.attempts != nil {
+		results = opts.attempts.Observe(healthCEP, results)
+	}
+	var checks []providerHealth
+	for r := range results {
+		check := providerHealth{Provider: r.APIName, Up: r.Error == nil, DurationMS: milliseconds(r.Duration)}
+		if r.Error != nil {
+			check.Error = r.Error.Error()
+		}
+		checks = append(checks, check)
+	}
+
+	// Report in the order of the providers rather than of the answers
+	position := make(map[string]int, len(providers))
+	for i, p := range providers {
+		position[p.Name()] = i
+	}
+	sort.Slice(checks, func(i, j int) bool { return position[checks[i].Provider] < position[checks[j].Provider] })
+
+	code := exitOK
+	for _, check := range checks {
+		if !check.Up {
+			code = exitFailure
+		}
+		if format == "json" {
+			json.NewEncoder(w).Encode(check)
+			continue
+		}
+		if check.Up {
+			fmt.Fprintf(w, "%s: up (%.3fs)\n", check.Provider, check.DurationMS/1000)
+		} else {
+			fmt.Fprintf(w, "%s: down (%.3fs): %s\n", check.Provider, check.DurationMS/1000, check.Error)
+		}
+	}
+	return code
+}
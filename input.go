@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// stdinArg is the argument that makes the CLI read CEPs from stdin
+const stdinArg = "-"
+
+// cepSource streams every CEP to resolve: the arguments in order, with each
+// "-" replaced by the CEPs read line by line from stdin. Ranges and
+// wildcard patterns are expanded, unless they exceed maxRange CEPs. The
+// channel is closed once the input is exhausted or ctx is done.
+func cepSource(ctx context.Context, args []string, stdin io.Reader, maxRange int) <-chan string {
+	out := make(chan string)
+	go func() {
+		defer close(out)
+		for _, arg := range args {
+			if arg != stdinArg {
+				if !sendExpanded(ctx, out, arg, maxRange) {
+					return
+				}
+				continue
+			}
+			if err := readCEPLines(ctx, stdin, out, maxRange); err != nil {
+				fmt.Fprintf(os.Stderr, "Erro ao ler os CEPs da entrada padrão: %v\n", err)
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// readCEPLines sends each line of r to out, skipping blank lines and lines
+// starting with #
+func readCEPLines(ctx context.Context, r io.Reader, out chan<- string, maxRange int) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !sendExpanded(ctx, out, line, maxRange) {
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+// sendExpanded sends raw, or every CEP of the pattern it denotes. Invalid
+// patterns and ones larger than maxRange are reported on stderr and skipped.
+func sendExpanded(ctx context.Context, out chan<- string, raw string, maxRange int) bool {
+	p, ok, err := parsePattern(raw)
+	switch {
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "Aviso: %v\n", err)
+		return true
+	case !ok:
+		return send(ctx, out, raw)
+	case p.size() > maxRange:
+		fmt.Fprintf(os.Stderr, "Aviso: o padrão %s gera %d CEPs, acima do limite de %d; ignorado\n", raw, p.size(), maxRange)
+		return true
+	}
+	return p.each(func(code string) bool { return send(ctx, out, code) })
+}
+
+// send delivers raw on out, giving up when ctx is done
+func send(ctx context.Context, out chan<- string, raw string) bool {
+	select {
+	case out <- raw:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
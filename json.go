@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/prodbygus/golang-multithreading/cep"
+)
+
+// jsonResult is the machine-readable output of a single lookup
+type jsonResult struct {
+	CEP        string       `json:"cep"`
+	Source     string       `json:"source,omitempty"`
+	DurationMS float64      `json:"duration_ms,omitempty"`
+	Address    *cep.Address `json:"address,omitempty"`
+	// Agreed lists the providers required to match (-min-providers)
+	Agreed     []string        `json:"agreed,omitempty"`
+	Comparison *jsonComparison `json:"comparison,omitempty"`
+	// Differences lists the fields where providers disagree (-diff only)
+	Differences []jsonDifference `json:"differences,omitempty"`
+	Error       string           `json:"error,omitempty"`
+	// Explanation narrates the race outcome (-explain only)
+	Explanation string `json:"explanation,omitempty"`
+	// CityMismatch maps provider to city when they disagree (-check-city)
+	CityMismatch map[string]string `json:"city_mismatch,omitempty"`
+	// ElapsedMS is the end-to-end time of the lookup, beyond the providers'
+	ElapsedMS float64 `json:"elapsed_ms,omitempty"`
+}
+
+// jsonComparison is the JSON form of the timing comparison section
+type jsonComparison struct {
+	Fastest      string             `json:"fastest,omitempty"`
+	Slowest      string             `json:"slowest,omitempty"`
+	DifferenceMS float64            `json:"difference_ms"`
+	DurationsMS  map[string]float64 `json:"durations_ms"`
+	Failed       map[string]string  `json:"failed,omitempty"`   // Provider name -> why it failed
+	Canceled     []string           `json:"canceled,omitempty"` // Providers aborted after losing the race
+}
+
+// jsonDifference is a field where a provider disagrees with the winner
+type jsonDifference struct {
+	Field  string            `json:"field"`
+	Values map[string]string `json:"values"` // Provider name -> value
+}
+
+// jsonFormatter writes one compact JSON object per line, for jq and other
+// line-oriented tools. With pretty it collects the results instead and
+// writes them indented at the end, as an array when there are several.
+type jsonFormatter struct {
+	pretty  bool
+	results []jsonResult
+}
+
+func (f *jsonFormatter) Begin(io.Writer) {}
+
+func (f *jsonFormatter) End(w io.Writer) {
+	if !f.pretty {
+		return
+	}
+	var v interface{} = f.results
+	if len(f.results) == 1 {
+		v = f.results[0]
+	}
+	out, _ := json.MarshalIndent(v, "", "  ")
+	fmt.Fprintf(w, "%s\n", out)
+}
+
+func (f *jsonFormatter) Format(w io.Writer, r lookupResult) {
+	if f.pretty {
+		f.results = append(f.results, newJSONResult(r))
+		return
+	}
+	json.NewEncoder(w).Encode(newJSONResult(r))
+}
+
+// newJSONResult converts r into its JSON representation
+func newJSONResult(r lookupResult) jsonResult {
+	out := jsonResult{CEP: r.displayCEP(), ElapsedMS: milliseconds(r.elapsed)}
+	if r.err != nil {
+		out.Error = r.err.Error()
+		return out
+	}
+
+	out.Source = r.winner.APIName
+	out.Address = &r.winner.Address
+	out.Agreed = r.agreed
+	out.CityMismatch = r.cities
+	if r.cached() {
+		return out
+	}
+	out.DurationMS = milliseconds(r.winner.Duration)
+	if r.summary == nil {
+		return out
+	}
+
+	timings := r.summary.timings
+	fastest, slowest := fastestAndSlowest(timings)
+	out.Comparison = &jsonComparison{
+		Fastest:      fastest,
+		Slowest:      slowest,
+		DifferenceMS: milliseconds(timings[slowest] - timings[fastest]),
+		DurationsMS:  make(map[string]float64, len(timings)),
+		Canceled:     r.summary.canceled,
+	}
+	for api, duration := range timings {
+		out.Comparison.DurationsMS[api] = milliseconds(duration)
+	}
+	if len(r.summary.failed) > 0 {
+		out.Comparison.Failed = make(map[string]string, len(r.summary.failed))
+		for _, f := range r.summary.failed {
+			out.Comparison.Failed[f.APIName] = failureReason(f)
+		}
+	}
+	if r.explain {
+		out.Explanation = explainRace(r.winner, r.summary)
+	}
+
+	if r.diff {
+		winner := r.summary.answers[0]
+		for _, other := range r.summary.answers[1:] {
+			for _, d := range cep.DiffAddresses(winner.Address, other.Address) {
+				out.Differences = append(out.Differences, jsonDifference{
+					Field:  d.Field,
+					Values: map[string]string{winner.APIName: d.A, other.APIName: d.B},
+				})
+			}
+		}
+	}
+	return out
+}
+
+// milliseconds converts d into fractional milliseconds
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
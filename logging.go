@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/prodbygus/golang-multithreading/cep"
+)
+
+// logFormats lists the values accepted by -log-format
+var logFormats = []string{"text", "json"}
+
+// logLevels lists the values accepted by -log-level
+var logLevels = []string{"debug", "info", "warn", "error"}
+
+// newLogger returns a logger writing to w as key=value text or JSON lines,
+// dropping events below level. format and level must be in logFormats and
+// logLevels.
+func newLogger(w io.Writer, format, level string) *slog.Logger {
+	var l slog.Level
+	l.UnmarshalText([]byte(level))
+	opts := &slog.HandlerOptions{Level: l}
+	if format == "json" {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
+
+// lookupOutcome classifies a lookup's error for the logs, along the same
+// lines as the status codes of -serve
+func lookupOutcome(err error) string {
+	var timeout timeoutError
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.Is(err, cep.ErrInvalidCEP):
+		return "invalid"
+	case errors.As(err, &timeout), errors.Is(err, errDeadline):
+		return "timeout"
+	case errors.Is(err, errCanceled):
+		return "canceled"
+	case errors.Is(err, cep.ErrCEPNotFound):
+		return "not_found"
+	default:
+		return "error"
+	}
+}
+
+// lookupAttrs describes a finished lookup as log attributes: the CEP, the
+// winner, the outcome and the duration of every provider that reported
+func lookupAttrs(id string, res lookupResult, elapsed time.Duration) []interface{} {
+	attrs := []interface{}{
+		slog.String("request_id", id),
+		slog.String("cep", res.input),
+		slog.String("outcome", lookupOutcome(res.err)),
+		slog.Float64("duration_ms", milliseconds(elapsed)),
+	}
+	if res.winner.APIName != "" {
+		attrs = append(attrs, slog.String("winner", res.winner.APIName))
+	}
+	if res.err != nil {
+		attrs = append(attrs, slog.String("error", shortError(res.err)))
+	}
+
+	durations := map[string]time.Duration{}
+	if res.winner.APIName != "" {
+		durations[res.winner.APIName] = res.winner.Duration
+	}
+	if res.summary != nil {
+		for api, d := range res.summary.timings {
+			durations[api] = d
+		}
+		for _, f := range res.summary.failed {
+			durations[f.APIName] = f.Duration
+		}
+	}
+	providers := make([]interface{}, 0, len(durations))
+	for _, api := range byDuration(durations) {
+		providers = append(providers, slog.Float64(api, milliseconds(durations[api])))
+	}
+	if len(providers) > 0 {
+		attrs = append(attrs, slog.Group("providers_ms", providers...))
+	}
+	return attrs
+}
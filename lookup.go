@@ -0,0 +1,376 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/prodbygus/golang-multithreading/cep"
+	"github.com/prodbygus/golang-multithreading/metrics"
+)
+
+// lookupOptions holds the settings shared by every lookup in a run
+type lookupOptions struct {
+	client   *cep.Client
+	metrics  *metrics.Registry // Nil when metrics are disabled
+	stats    *metrics.Stats    // Rolling latency percentiles, nil outside -serve
+	cache    *cep.Cache        // Nil when caching is disabled
+	attempts *attemptLog       // Nil without -attempts-log
+	budget   *requestBudget    // Lookups left before -serve exits, nil without -count
+	webhook  *webhook          // Receives every resolved address, nil without -webhook
+
+	// fastestOnly skips waiting for the losing providers and the timing
+	// comparison
+	fastestOnly bool
+
+	// diff keeps the losing providers running so their answers can be
+	// compared field by field with the winner
+	diff bool
+
+	// checkCity keeps the losing providers running and flags lookups where
+	// they disagree on the city
+	checkCity bool
+
+	// minProviders is how many providers must answer, and agree, before a
+	// lookup succeeds. Values below 2 take the fastest answer.
+	minProviders int
+
+	// firstN, above 1, waits for that many successful answers, agreeing or
+	// not, and cancels the other providers; the comparisons only see them
+	firstN int
+
+	// explain narrates the outcome of every provider after each lookup
+	explain bool
+
+	// compareOnly waits for every provider and records the fields their
+	// answers disagree on, for -compare-only
+	compareOnly bool
+
+	// slowThreshold, when positive, makes -serve log a warning for every
+	// lookup that takes longer
+	slowThreshold time.Duration
+
+	// cepStyle is how the CEPs of the resolved addresses are written,
+	// whichever provider answered: "dashed" (01153-000) or "raw" (01153000)
+	cepStyle string
+}
+
+// cepStyles lists the values accepted by -format-cep
+var cepStyles = []string{"dashed", "raw"}
+
+// styleCEP writes code in style, leaving it untouched if it isn't a CEP
+func styleCEP(code, style string) string {
+	digits, err := cep.Normalize(code)
+	if err != nil {
+		return code
+	}
+	if style == "dashed" {
+		return digits[:5] + "-" + digits[5:]
+	}
+	return digits
+}
+
+// waitAll reports whether the losing providers must keep running after the
+// winner is known, because their answers are needed
+func (o lookupOptions) waitAll() bool {
+	return (o.diff || o.checkCity || o.compareOnly) && o.firstN < 2
+}
+
+// notify hands a successful lookup to the webhook, if there is one
+func (o lookupOptions) notify(res lookupResult) {
+	if o.webhook != nil && res.err == nil {
+		o.webhook.Send(res)
+	}
+}
+
+// cachedResponse returns the cached response for code, if caching is enabled
+func (o lookupOptions) cachedResponse(code string) (cep.Response, bool) {
+	if o.cache == nil {
+		return cep.Response{}, false
+	}
+	return o.cache.Get(code)
+}
+
+// storeResponse caches the winning response for code, if caching is enabled
+func (o lookupOptions) storeResponse(code string, result cep.Response) {
+	if o.cache != nil {
+		o.cache.Set(code, result)
+	}
+}
+
+// race starts the provider race (or fallback chain) for code, recording
+// metrics when enabled
+func (o lookupOptions) race(ctx context.Context, code string) <-chan cep.Response {
+	results := o.client.Race(ctx, code)
+	if o.metrics != nil {
+		results = o.metrics.Observe(results)
+	}
+	if o.stats != nil {
+		results = o.stats.Observe(results)
+	}
+	if o.attempts != nil {
+		results = o.attempts.Observe(code, results)
+	}
+	return results
+}
+
+// collect waits for the successful responses a lookup needs: the fastest
+// one, minProviders agreeing ones or the first firstN
+func (o lookupOptions) collect(ctx context.Context, results <-chan cep.Response) ([]cep.Response, error) {
+	if o.minProviders > 1 {
+		return cep.Quorum(ctx, results, o.minProviders)
+	}
+	if o.firstN > 1 {
+		return cep.FirstN(ctx, results, o.firstN)
+	}
+	result, err := cep.Fastest(ctx, results)
+	if err != nil {
+		return nil, err
+	}
+	return []cep.Response{result}, nil
+}
+
+// errCanceled is the error of lookups aborted by the user (SIGINT)
+var errCanceled = errors.New("consulta cancelada")
+
+// errDeadline is the error of lookups cut off by a deadline over the whole
+// run, such as -batch-timeout, rather than by their own timeout
+var errDeadline = errors.New("prazo total esgotado")
+
+// timeoutError reports that no provider answered within the lookup timeout
+type timeoutError struct {
+	timeout time.Duration
+}
+
+func (e timeoutError) Error() string {
+	return "timeout após " + e.timeout.String()
+}
+
+// lookupResult is the outcome of resolving a single CEP, rendered later by
+// an OutputFormatter
+type lookupResult struct {
+	input   string       // CEP as given by the user
+	cep     string       // Normalized CEP, empty when the input is invalid
+	winner  cep.Response // Fastest successful response, or the cached one
+	summary *raceSummary // Nil for cache hits and with -fastest-only
+	diff    bool         // Whether field differences were requested
+	explain bool         // Whether the race outcome should be narrated
+	err     error
+
+	// cepStyle is the -format-cep style, applied to the CEP by displayCEP
+	cepStyle string
+
+	// agreed lists the providers whose answers were required to match, when
+	// -min-providers is above 1
+	agreed []string
+
+	// cities maps provider name to city when -check-city found that the
+	// providers disagree, nil otherwise
+	cities map[string]string
+
+	// mismatches lists the fields the providers disagree on, with
+	// -compare-only
+	mismatches []string
+
+	// elapsed is the wall-clock time from picking up the input to printing
+	// its result, including normalization and scheduling; zero when the
+	// caller doesn't measure it
+	elapsed time.Duration
+}
+
+// displayCEP is the CEP written in the output: the normalized CEP in the
+// -format-cep style, or the raw input when it is invalid
+func (r lookupResult) displayCEP() string {
+	if r.cep == "" {
+		return r.input
+	}
+	return styleCEP(r.cep, r.cepStyle)
+}
+
+// cached reports whether the result was served from the cache
+func (r lookupResult) cached() bool {
+	return r.winner.APIName == cep.CacheSource
+}
+
+// resolveCEP races every provider for a single CEP and returns the fastest
+// response along with the outcome of the other providers. Each call uses
+// its own context and timing map, so lookups in a batch don't interfere
+// with each other.
+func resolveCEP(parent context.Context, opts lookupOptions, raw string) lookupResult {
+	res := lookupResult{input: raw, diff: opts.diff, explain: opts.explain, cepStyle: opts.cepStyle}
+
+	code, err := cep.Normalize(raw)
+	if err != nil {
+		res.err = err
+		return res
+	}
+	res.cep = code
+
+	// Tag the lookup so its log lines and outbound requests can be
+	// correlated; the server mode sets the ID from the incoming request
+	if cep.RequestID(parent) == "" {
+		parent = cep.WithRequestID(parent, cep.NewRequestID())
+	}
+
+	if result, ok := opts.cachedResponse(code); ok {
+		res.winner = result
+		res.winner.Address.CEP = styleCEP(res.winner.Address.CEP, opts.cepStyle)
+		return res
+	}
+
+	// Create context with timeout
+	ctx, cancel := context.WithTimeout(parent, opts.client.Timeout())
+	defer cancel()
+
+	// The race gets its own cancelable context so the losing providers are
+	// aborted as soon as a winner is known
+	raceCtx, cancelRace := context.WithCancel(ctx)
+	defer cancelRace()
+	var failed []cep.Response
+	results := recordFailures(opts.race(raceCtx, code), &failed)
+
+	// Wait for the first successful responses, all providers failing or timeout
+	answers, err := opts.collect(raceCtx, results)
+	if !opts.waitAll() {
+		cancelRace()
+	}
+	if err != nil {
+		// Check the contexts rather than err: a provider cut off by
+		// -provider-timeout also fails with context.DeadlineExceeded
+		switch {
+		case errors.Is(parent.Err(), context.DeadlineExceeded):
+			res.err = errDeadline
+		case parent.Err() != nil:
+			res.err = errCanceled
+		case ctx.Err() != nil:
+			res.err = timeoutError{opts.client.Timeout()}
+		default:
+			res.err = err
+		}
+		return res
+	}
+
+	opts.storeResponse(code, answers[0])
+	res.winner = answers[0]
+	res.winner.Address.CEP = styleCEP(res.winner.Address.CEP, opts.cepStyle)
+	if opts.minProviders > 1 {
+		for _, a := range answers {
+			res.agreed = append(res.agreed, a.APIName)
+		}
+	}
+
+	if !opts.fastestOnly {
+		summary := collectRace(answers, results)
+		for i := range summary.answers {
+			summary.answers[i].Address.CEP = styleCEP(summary.answers[i].Address.CEP, opts.cepStyle)
+		}
+		summary.failed = failed
+		res.summary = &summary
+		if opts.checkCity {
+			res.cities = cityConflict(summary.answers)
+		}
+		if opts.compareOnly {
+			res.mismatches = mismatchedFields(summary.answers)
+		}
+	}
+	return res
+}
+
+// cityConflict returns the city reported by each provider when any of them
+// disagrees with the winner, or nil when they all agree
+func cityConflict(answers []cep.Response) map[string]string {
+	conflict := false
+	for _, a := range answers[1:] {
+		if !cep.SameCity(answers[0].Address.City, a.Address.City) {
+			conflict = true
+		}
+	}
+	if !conflict {
+		return nil
+	}
+
+	cities := make(map[string]string, len(answers))
+	for _, a := range answers {
+		cities[a.APIName] = a.Address.City
+	}
+	return cities
+}
+
+// raceSummary is the outcome of every provider in a race that had a winner
+type raceSummary struct {
+	timings  map[string]time.Duration // Successful providers, including the winner
+	answers  []cep.Response           // Successful responses, winner first
+	canceled []string                 // Providers aborted after losing the race
+	failed   []cep.Response           // Providers that errored or timed out
+}
+
+// collectRace reads the remaining responses after answers, the ones already
+// received with the winner first, until every provider has reported. Race
+// closes the channel once all providers are done, so this returns exactly
+// when the work is over. Providers aborted because they lost the race aren't
+// errors and are returned separately.
+func collectRace(answers []cep.Response, results <-chan cep.Response) raceSummary {
+	summary := raceSummary{
+		timings: make(map[string]time.Duration, len(answers)),
+		answers: answers,
+	}
+	for _, a := range answers {
+		summary.timings[a.APIName] = a.Duration
+	}
+	for r := range results {
+		switch {
+		case errors.Is(r.Error, context.Canceled):
+			summary.canceled = append(summary.canceled, r.APIName)
+		case r.Error == nil:
+			summary.timings[r.APIName] = r.Duration
+			summary.answers = append(summary.answers, r)
+		}
+	}
+	return summary
+}
+
+// recordFailures forwards results, appending every failed response other
+// than a cancellation to *failed. *failed may be read once the returned
+// channel is closed.
+func recordFailures(results <-chan cep.Response, failed *[]cep.Response) <-chan cep.Response {
+	out := make(chan cep.Response, cap(results))
+	go func() {
+		defer close(out)
+		for r := range results {
+			if r.Error != nil && !errors.Is(r.Error, context.Canceled) {
+				*failed = append(*failed, r)
+			}
+			out <- r
+		}
+	}()
+	return out
+}
+
+// byDuration returns the APIs in timings ordered from fastest to slowest
+func byDuration(timings map[string]time.Duration) []string {
+	apis := make([]string, 0, len(timings))
+	for api := range timings {
+		apis = append(apis, api)
+	}
+	sort.Slice(apis, func(i, j int) bool {
+		if timings[apis[i]] != timings[apis[j]] {
+			return timings[apis[i]] < timings[apis[j]]
+		}
+		return apis[i] < apis[j]
+	})
+	return apis
+}
+
+// fastestAndSlowest returns the APIs with the lowest and highest durations
+func fastestAndSlowest(timings map[string]time.Duration) (fastest, slowest string) {
+	for api, duration := range timings {
+		if fastest == "" || duration < timings[fastest] {
+			fastest = api
+		}
+		if slowest == "" || duration > timings[slowest] {
+			slowest = api
+		}
+	}
+	return fastest, slowest
+}
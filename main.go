@@ -2,237 +2,708 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
-	"sync"
+	"os/signal"
+	"strings"
 	"time"
-)
 
-// BrasilAPICEP represents the structure returned by BrasilAPI
-type BrasilAPICEP struct {
-	Cep          string `json:"cep"`
-	State        string `json:"state"`
-	City         string `json:"city"`
-	Neighborhood string `json:"neighborhood"`
-	Street       string `json:"street"`
-	Service      string `json:"service"`
-}
+	"github.com/prodbygus/golang-multithreading/cep"
+	"github.com/prodbygus/golang-multithreading/metrics"
+)
 
-// ViaCEP represents the structure returned by ViaCEP API
-type ViaCEP struct {
-	Cep         string `json:"cep"`
-	Logradouro  string `json:"logradouro"`
-	Complemento string `json:"complemento"`
-	Bairro      string `json:"bairro"`
-	Localidade  string `json:"localidade"`
-	Uf          string `json:"uf"`
-	Ibge        string `json:"ibge"`
-	Gia         string `json:"gia"`
-	Ddd         string `json:"ddd"`
-	Siafi       string `json:"siafi"`
-}
+func main() {
+	timeout := flag.Duration("timeout", 1*time.Second, "tempo máximo de espera pelas APIs em cada CEP (ex: 500ms, 3s)")
+	flag.DurationVar(timeout, "timeout-per-cep", *timeout, "o mesmo que -timeout")
+	deadlineFlag := flag.String("deadline", "", "horário limite absoluto, em RFC3339, para as consultas (ex: 2026-01-02T15:04:05-03:00); vale o que chegar antes entre ele e -timeout")
+	batchTimeout := flag.Duration("batch-timeout", 0, "prazo total para um lote de CEPs; nenhuma consulta começa depois dele (0 = sem prazo)")
+	providerTimeout := flag.Duration("provider-timeout", 0, "tempo máximo de espera por cada API individualmente (0 usa apenas -timeout)")
+	format := flag.String("format", "text", "formato da saída: "+strings.Join(formatNames, ", "))
+	pretty := flag.Bool("pretty", false, "com -format json, indenta a saída; vários CEPs viram um array JSON escrito ao fim do lote")
+	color := flag.String("color", "auto", "cores na saída de texto: auto (apenas em terminal, respeitando NO_COLOR), always ou never")
+	jsonOutput := flag.Bool("json", false, "exibe o resultado em JSON (o mesmo que -format json)")
+	formatCEP := flag.String("format-cep", "dashed", "como o CEP do endereço é exibido: dashed (01153-000) ou raw (01153000)")
+	quiet := flag.Bool("quiet", false, "exibe apenas \"rua, cidade - UF\", sem comparativo (o mesmo que -format quiet)")
+	retries := flag.Int("retries", cep.Retries, "número de novas tentativas em erros de rede, 5xx ou 429")
+	maxRetryAfter := flag.Duration("max-retry-after", cep.MaxRetryAfter, "espera máxima pedida por um Retry-After antes de uma nova tentativa (0 = limitada apenas pelo -timeout)")
+	retryJitter := flag.String("retry-jitter", cep.RetryJitter, "aleatoriedade da espera entre tentativas: none, full ou decorrelated")
+	retryIncomplete := flag.Int("retry-incomplete", 0, "número de novas tentativas quando uma API responde sem rua ou bairro")
+	maxResponseSize := flag.Int64("max-response-size", cep.MaxResponseSize, "tamanho máximo, em bytes, do corpo de uma resposta das APIs (0 desativa)")
+	userAgent := flag.String("user-agent", envOr("CEP_USER_AGENT", cep.UserAgent), "User-Agent enviado às APIs (ou variável CEP_USER_AGENT)")
+	attemptsLog := flag.String("attempts-log", "", "arquivo CSV onde cada resposta de cada API é acrescentada (horário, CEP, API, resultado, duração, status HTTP, erro)")
+	output := flag.String("output", "", "arquivo onde cada endereço encontrado é acrescentado (JSON por linha)")
+	stdin := flag.Bool("stdin", false, "lê os CEPs da entrada padrão, um por linha (o mesmo que o argumento -)")
+	sortBy := flag.String("sort", "", "ordena a saída do lote ao final: "+strings.Join(sortNames, ", ")+" (vazio exibe conforme as consultas terminam)")
+	maxRange := flag.Int("max-range", 100, "número máximo de CEPs gerados por um intervalo (01153000-01153010) ou padrão (0115300*)")
+	concurrency := flag.Int("concurrency", 4, "número máximo de CEPs consultados ao mesmo tempo em lote")
+	reverse := flag.Bool("reverse", false, "busca os CEPs de um endereço na ViaCEP: -reverse <UF> <cidade> <rua>")
+	warmup := flag.Int("warmup", 0, "corridas descartadas antes das medições de -bench, para aquecer as conexões")
+	dryRunFlag := flag.Bool("dry-run", false, "exibe as URLs que cada API consultaria e encerra sem fazer requisições")
+	healthcheck := flag.Bool("healthcheck", false, "consulta um CEP conhecido em cada API e informa se ela está no ar e sua latência")
+	bench := flag.Int("bench", 0, "executa N corridas para o CEP e exibe estatísticas de latência por API")
+	explain := flag.Bool("explain", false, "explica após cada consulta por que cada API venceu, perdeu ou falhou")
+	diff := flag.Bool("diff", false, "aguarda todas as APIs e exibe os campos em que divergem")
+	compareOnly := flag.Bool("compare-only", false, "aguarda todas as APIs e exibe seus campos lado a lado, sem tempos (código de saída 2 se divergirem)")
+	checkCity := flag.Bool("check-city", false, "aguarda todas as APIs e avisa (com código de saída 2) quando divergem sobre a cidade")
+	providerNames := flag.String("providers", "", "APIs consultadas, separadas por vírgula (ex: brasilapi,viacep); vazio usa todas")
+	mode := flag.String("mode", "race", "estratégia de consulta: race (todas as APIs ao mesmo tempo) ou fallback (uma de cada vez, na ordem de -order)")
+	order := flag.String("order", "", "ordem das APIs separadas por vírgula, usada por -mode fallback (ex: viacep,brasilapi)")
+	firstN := flag.Int("first-n", 1, "espera as N primeiras APIs que responderem com sucesso, cancela as demais e compara apenas essas")
+	minProviders := flag.Int("min-providers", 1, "número de APIs que precisam responder e concordar sobre o endereço")
+	timingOutput := flag.String("timing-output", "stdout", "destino do comparativo de tempo e do tempo total na saída de texto: stdout, stderr ou none")
+	fastestOnly := flag.Bool("fastest-only", false, "exibe apenas a resposta mais rápida, sem o comparativo de tempo")
+	cacheTTL := flag.Duration("cache-ttl", 24*time.Hour, "tempo de validade das respostas em cache (0 desativa o cache)")
+	noCache := flag.Bool("no-cache", false, "ignora o cache nesta execução: nenhuma resposta é lida ou gravada")
+	cacheDir := flag.String("cache-dir", "", "diretório onde as respostas em cache são gravadas, um arquivo JSON por CEP, para reaproveitá-las entre execuções")
+	clearCache := flag.Bool("clear-cache", false, "remove todas as respostas em cache antes das consultas (sem CEPs, apenas limpa e encerra)")
+	serveAddr := flag.String("serve", "", "inicia um servidor HTTP com GET /cep/{código} no endereço (ex: :8080)")
+	rateLimit := flag.Float64("rate-limit", 0, "máximo de requisições por segundo a cada API, com rajadas de até um segundo (ex: 2 ou 0.5; 0 desativa)")
+	webhookURL := flag.String("webhook", "", "envia por POST, em JSON, o endereço de cada consulta bem-sucedida para a URL")
+	webhookTimeout := flag.Duration("webhook-timeout", 5*time.Second, "tempo máximo de cada tentativa de entrega do -webhook")
+	serveCount := flag.Int("count", 0, "com -serve, encerra o servidor após atender N consultas e imprime as estatísticas de latência (0 = sem limite)")
+	slowThreshold := flag.Duration("slow-threshold", 0, "com -serve, registra um aviso no stderr para cada consulta mais lenta que isso (ex: 800ms; 0 desativa)")
+	breakerThreshold := flag.Int("breaker-threshold", 5, "com -serve, falhas consecutivas que abrem o circuito de uma API (0 desativa)")
+	breakerCooldown := flag.Duration("breaker-cooldown", 30*time.Second, "com -serve, tempo que o circuito fica aberto antes de testar a API novamente")
+	metricsAddr := flag.String("metrics-addr", "", "endereço onde expor as métricas em /metrics (ex: :9090)")
+	configPath := flag.String("config", "", "arquivo JSON com URL, timeout, tentativas e habilitação de cada API (as flags têm prioridade)")
+	ipVersion := flag.String("ip-version", "auto", "família de endereços das conexões às APIs: 4 (apenas IPv4), 6 (apenas IPv6) ou auto")
+	maxIdleConns := flag.Int("max-idle-conns", defaultTransport.maxIdleConns, "conexões ociosas mantidas abertas para cada API, para reuso")
+	idleConnTimeout := flag.Duration("idle-conn-timeout", defaultTransport.idleConnTimeout, "tempo que uma conexão ociosa é mantida antes de ser fechada")
+	disableKeepAlives := flag.Bool("disable-keep-alives", false, "abre uma conexão nova para cada requisição, sem reuso")
+	verbose := flag.Bool("verbose", false, "registra cada etapa das requisições no stderr (o mesmo que -log-level debug)")
+	tlsInfo := flag.Bool("tls-info", false, "exibe no stderr a versão do TLS, a cifra e o emissor e a validade do certificado de cada API")
+	logFormat := flag.String("log-format", "text", "formato do log no stderr: text (chave=valor) ou json")
+	logLevel := flag.String("log-level", "info", "nível mínimo do log: debug, info, warn ou error")
+	brasilAPIVersion := flag.Int("brasilapi-version", 1, "versão da BrasilAPI: 1 ou 2 (inclui as coordenadas do CEP)")
+	brasilAPIURL := flag.String("brasilapi-url", cep.BrasilAPIBaseURL, "URL base da BrasilAPI")
+	viaCEPURL := flag.String("viacep-url", cep.ViaCEPBaseURL, "URL base da ViaCEP")
+	postmonURL := flag.String("postmon-url", cep.PostmonBaseURL, "URL base da Postmon")
+	openCEPURL := flag.String("opencep-url", cep.OpenCEPBaseURL, "URL base da OpenCEP (ex: uma instância própria)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Uso: go run main.go [subcomando] [flags] <cep> [cep...]\n\n")
+		printSubcommands()
+		fmt.Fprintf(flag.CommandLine.Output(), "Flags:\n")
+		flag.PrintDefaults()
+	}
+	// Parse errors exit with exitUsage rather than the flag package's 2,
+	// which is taken by exitInconsistent
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	args, err := parseCommandLine(os.Args[1:])
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(exitOK)
+		}
+		os.Exit(exitUsage)
+	}
 
-// Response represents a generic API response with the API source
-type Response struct {
-	Data     interface{}
-	APIName  string
-	Error    error
-	Duration time.Duration // Add duration field to track response time
-}
+	if *timeout <= 0 {
+		usageError("O timeout deve ser maior que zero.")
+	}
+	if *batchTimeout < 0 {
+		usageError("O prazo do lote não pode ser negativo.")
+	}
+	var deadline time.Time
+	if *deadlineFlag != "" {
+		deadline, err = time.Parse(time.RFC3339, *deadlineFlag)
+		if err != nil {
+			usageError(fmt.Sprintf("Horário inválido em -deadline: %q (use RFC3339, ex: 2026-01-02T15:04:05-03:00).", *deadlineFlag))
+		}
+		if !deadline.After(time.Now()) {
+			usageError(fmt.Sprintf("O horário de -deadline (%s) já passou.", deadline.Format(time.RFC3339)))
+		}
+		// Only the batch honors it; the other modes would silently ignore it
+		for _, m := range []struct {
+			name string
+			on   bool
+		}{{"serve", *serveAddr != ""}, {"bench", *bench > 0}, {"healthcheck", *healthcheck}, {"reverse", *reverse}} {
+			if m.on {
+				usageError(fmt.Sprintf("-deadline não pode ser usado com -%s.", m.name))
+			}
+		}
+	}
 
-func main() {
-	if len(os.Args) < 2 {
-		fmt.Println("Por favor, forneça um CEP como argumento. Exemplo: go run main.go 01153000")
-		return
+	if *providerTimeout < 0 {
+		usageError("O timeout por API não pode ser negativo.")
 	}
+	cep.ProviderTimeout = *providerTimeout
 
-	cep := os.Args[1]
-	fmt.Printf("Buscando informações para o CEP: %s\n", cep)
+	if *retries < 0 {
+		usageError("O número de tentativas não pode ser negativo.")
+	}
+	cep.Retries = *retries
 
-	// Create context with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
-	defer cancel()
+	if *retryIncomplete < 0 {
+		usageError("O número de tentativas para respostas incompletas não pode ser negativo.")
+	}
+	cep.IncompleteRetries = *retryIncomplete
+	cep.UserAgent = *userAgent
 
-	// Channel to receive responses
-	resultChan := make(chan Response, 2)
+	if !contains(jitterNames, *retryJitter) {
+		usageError(fmt.Sprintf("Estratégia de jitter desconhecida: %q (use %s).", *retryJitter, strings.Join(jitterNames, ", ")))
+	}
+	cep.RetryJitter = *retryJitter
 
-	// Wait group to wait for both API calls to complete
-	var wg sync.WaitGroup
-	wg.Add(2)
+	if *maxRetryAfter < 0 {
+		usageError("A espera máxima do Retry-After não pode ser negativa.")
+	}
+	cep.MaxRetryAfter = *maxRetryAfter
 
-	// Map to store timing results
-	timingResults := make(map[string]time.Duration)
-	var timingMutex sync.Mutex
+	if *maxResponseSize < 0 {
+		usageError("O tamanho máximo da resposta não pode ser negativo.")
+	}
+	cep.MaxResponseSize = *maxResponseSize
+	if !contains(logFormats, *logFormat) {
+		usageError(fmt.Sprintf("Formato de log desconhecido: %q (use %s).", *logFormat, strings.Join(logFormats, ", ")))
+	}
+	if !contains(logLevels, *logLevel) {
+		usageError(fmt.Sprintf("Nível de log desconhecido: %q (use %s).", *logLevel, strings.Join(logLevels, ", ")))
+	}
+	if *verbose {
+		*logLevel = "debug"
+	}
+	logger := newLogger(os.Stderr, *logFormat, *logLevel)
+	slog.SetDefault(logger)
+	cep.Logger = logger
+	if *tlsInfo {
+		cep.TLSObserver = newTLSReport(os.Stderr).Observe
+	}
 
-	// Start goroutines to fetch data from both APIs
-	go fetchBrasilAPI(ctx, cep, resultChan, &wg, &timingMutex, timingResults)
-	go fetchViaCEP(ctx, cep, resultChan, &wg, &timingMutex, timingResults)
+	// Flags given on the command line override the config file
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	var cfg fileConfig
+	if *configPath != "" {
+		if cfg, err = loadConfig(*configPath); err != nil {
+			usageError(fmt.Sprintf("Erro no arquivo de configuração: %v", err))
+		}
+	}
+	*brasilAPIURL = cfg.baseURL("brasilapi", set["brasilapi-url"], *brasilAPIURL)
+	switch *brasilAPIVersion {
+	case 1:
+	case 2:
+		// Only the public endpoint is switched; a custom -brasilapi-url is
+		// used as given
+		if *brasilAPIURL == cep.BrasilAPIBaseURL {
+			*brasilAPIURL = cep.BrasilAPIV2BaseURL
+		}
+	default:
+		usageError("A versão da BrasilAPI deve ser 1 ou 2.")
+	}
+	*viaCEPURL = cfg.baseURL("viacep", set["viacep-url"], *viaCEPURL)
+	*postmonURL = cfg.baseURL("postmon", set["postmon-url"], *postmonURL)
+	*openCEPURL = cfg.baseURL("opencep", set["opencep-url"], *openCEPURL)
 
-	// Wait for the first response or timeout
-	select {
-	case result := <-resultChan:
-		if result.Error != nil {
-			fmt.Printf("Erro na API %s: %v\n", result.APIName, result.Error)
-			return
+	if *jsonOutput {
+		*format = "json"
+	}
+	if *quiet {
+		*format = "quiet"
+	}
+	colors, err := newPalette(*color, os.Stdout)
+	if err != nil {
+		usageError(err.Error())
+	}
+	timing, err := timingDestination(*timingOutput)
+	if err != nil {
+		usageError(err.Error())
+	}
+	formatter, err := newFormatter(*format, colors, timing)
+	if err != nil {
+		usageError(err.Error())
+	}
+	// The comparison table replaces the formatter, so any other format
+	// would be silently ignored
+	if *compareOnly && *format != "text" {
+		usageError(fmt.Sprintf("-compare-only exibe sua própria tabela e não pode ser usado com -format %s.", *format))
+	}
+	if *pretty {
+		jf, ok := formatter.(*jsonFormatter)
+		if !ok {
+			usageError("-pretty só pode ser usado com -format json.")
 		}
+		jf.pretty = true
+	}
+	if *compareOnly {
+		formatter = &compareFormatter{color: colors}
+	}
 
-		fmt.Printf("Resposta mais rápida da API: %s (%.3fs)\n\n", result.APIName, result.Duration.Seconds())
+	if *maxIdleConns < 1 {
+		usageError("O número de conexões ociosas deve ser maior que zero.")
+	}
+	if *idleConnTimeout < 0 {
+		usageError("O tempo de conexão ociosa não pode ser negativo.")
+	}
 
-		switch data := result.Data.(type) {
-		case BrasilAPICEP:
-			fmt.Printf("CEP: %s\nEstado: %s\nCidade: %s\nBairro: %s\nRua: %s\n",
-				data.Cep, data.State, data.City, data.Neighborhood, data.Street)
-		case ViaCEP:
-			fmt.Printf("CEP: %s\nEstado: %s\nCidade: %s\nBairro: %s\nRua: %s\n",
-				data.Cep, data.Uf, data.Localidade, data.Bairro, data.Logradouro)
-		}
-	case <-ctx.Done():
-		fmt.Println("Erro: Timeout após 1 segundo")
-		return
+	if !contains(ipVersions, *ipVersion) {
+		usageError(fmt.Sprintf("Versão de IP desconhecida: %q (use %s).", *ipVersion, strings.Join(ipVersions, ", ")))
 	}
 
-	// Start a goroutine to wait for all results and display comparative timing
-	go func() {
-		wg.Wait() // Wait for both API calls to complete or timeout
+	if !contains(cepStyles, *formatCEP) {
+		usageError(fmt.Sprintf("Formato de CEP desconhecido: %q (use %s).", *formatCEP, strings.Join(cepStyles, ", ")))
+	}
 
-		// Print comparative timing results
-		fmt.Println("\n=== Comparativo de Tempo de Resposta ===")
-		timingMutex.Lock()
-		defer timingMutex.Unlock()
+	if *sortBy != "" && !contains(sortNames, *sortBy) {
+		usageError(fmt.Sprintf("Ordenação desconhecida: %q (use %s).", *sortBy, strings.Join(sortNames, ", ")))
+	}
 
-		// Check if we have both results
-		if len(timingResults) > 1 {
-			// Find the fastest and slowest
-			var fastest, slowest string
-			var fastestTime, slowestTime time.Duration
+	if *warmup < 0 {
+		usageError("O número de corridas de aquecimento não pode ser negativo.")
+	}
 
-			for api, duration := range timingResults {
-				if fastest == "" || duration < fastestTime {
-					fastest = api
-					fastestTime = duration
-				}
-				if slowest == "" || duration > slowestTime {
-					slowest = api
-					slowestTime = duration
-				}
-			}
+	if *serveCount < 0 {
+		usageError("O número de consultas do servidor não pode ser negativo.")
+	}
 
-			// Print results
-			fmt.Printf("API mais rápida: %s (%.3fs)\n", fastest, fastestTime.Seconds())
-			fmt.Printf("API mais lenta: %s (%.3fs)\n", slowest, slowestTime.Seconds())
-			fmt.Printf("Diferença: %.3fs\n", slowestTime.Seconds()-fastestTime.Seconds())
+	if *rateLimit < 0 {
+		usageError("O limite de requisições não pode ser negativo.")
+	}
 
-			for api, duration := range timingResults {
-				fmt.Printf("%s: %.3fs\n", api, duration.Seconds())
-			}
-		} else {
-			fmt.Println("Não foi possível obter resposta de ambas as APIs para comparação.")
+	if *concurrency < 1 {
+		usageError("A concorrência deve ser maior que zero.")
+	}
+
+	if *stdin {
+		args = append(args, stdinArg)
+	}
+	if len(args) < 1 && *serveAddr == "" && !*clearCache && !*healthcheck {
+		usageError("Por favor, forneça um ou mais CEPs como argumento. Exemplo: go run main.go 01153000 20040002")
+	}
+
+	// Confirm oversized ranges before any request, while stdin is free
+	limit, err := confirmPatterns(args, *maxRange, os.Stdin, os.Stderr)
+	if err != nil {
+		usageError(err.Error())
+	}
+
+	// Open the output file before any request so a bad path fails fast
+	var outputFile *os.File
+	if *output != "" {
+		f, err := os.OpenFile(*output, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Erro ao abrir o arquivo de saída: %v\n", err)
+			os.Exit(exitFailure)
 		}
-	}()
+		defer f.Close()
+		outputFile = f
+	}
 
-	// Read the second response to clear the channel
-	select {
-	case <-resultChan: // Discard the second response
-	case <-time.After(100 * time.Millisecond): // Small timeout in case second response never arrives
+	var attempts *attemptLog
+	if *attemptsLog != "" {
+		l, f, err := openAttemptLog(*attemptsLog)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Erro ao abrir o log de tentativas: %v\n", err)
+			os.Exit(exitFailure)
+		}
+		defer f.Close()
+		attempts = l
 	}
 
-	// Give time for the timing comparison to be displayed
-	time.Sleep(200 * time.Millisecond)
-}
+	// Ctrl+C cancels the root context, aborting every in-flight request
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
 
-func fetchBrasilAPI(ctx context.Context, cep string, resultChan chan<- Response, wg *sync.WaitGroup, mu *sync.Mutex, results map[string]time.Duration) {
-	defer wg.Done()
-	startTime := time.Now()
-	apiName := "BrasilAPI"
+	client := newHTTPClient(*timeout, transportConfig{
+		ipVersion:         *ipVersion,
+		maxIdleConns:      *maxIdleConns,
+		idleConnTimeout:   *idleConnTimeout,
+		disableKeepAlives: *disableKeepAlives,
+	})
 
-	url := fmt.Sprintf("https://brasilapi.com.br/api/cep/v1/%s", cep)
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
-	if err != nil {
-		resultChan <- Response{APIName: apiName, Error: err, Duration: time.Since(startTime)}
+	if *reverse {
+		if len(args) != 3 {
+			usageError("Uso de -reverse: go run main.go -reverse <UF> <cidade> <rua>")
+		}
+		via := cep.ViaCEPProvider{Client: client, BaseURL: *viaCEPURL}
+		if *dryRunFlag {
+			fmt.Printf("  %s: GET %s\n", via.Name(), via.SearchURL(args[0], args[1], args[2]))
+			return
+		}
+		code := runReverse(ctx, os.Stdout, via, *timeout, *format, args[0], args[1], args[2])
+		exitIfInterrupted(ctx)
+		if code != exitOK {
+			os.Exit(code)
+		}
 		return
 	}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	providers, err := applyConfig(newProviders(client, *brasilAPIURL, *viaCEPURL, *postmonURL, *openCEPURL), cfg, set)
+	if err != nil {
+		usageError(err.Error())
+	}
+	providers, err = selectProviders(providers, *providerNames)
+	if err != nil {
+		usageError(err.Error())
+	}
+	providers, err = orderProviders(providers, *order)
 	if err != nil {
-		resultChan <- Response{APIName: apiName, Error: err, Duration: time.Since(startTime)}
+		usageError(err.Error())
+	}
+	if *rateLimit > 0 {
+		for i, p := range providers {
+			providers[i] = cep.RateLimited{CEPProvider: p, Limiter: cep.NewLimiter(*rateLimit, int(*rateLimit))}
+		}
+	}
+	if *mode != "race" && *mode != "fallback" {
+		usageError(fmt.Sprintf("Modo desconhecido: %q (use race ou fallback).", *mode))
+	}
+	clientOpts := []cep.Option{cep.WithProviders(providers...), cep.WithTimeout(*timeout)}
+	if *mode == "fallback" {
+		clientOpts = append(clientOpts, cep.WithFallback())
+	}
+	// -clear-cache purges the cache even when -cache-ttl 0 turns it off for
+	// this run's lookups
+	var cache *cep.Cache
+	if *cacheTTL > 0 || *clearCache {
+		if *cacheDir != "" {
+			if cache, err = cep.NewDiskCache(*cacheDir, *cacheTTL); err != nil {
+				fmt.Fprintf(os.Stderr, "Erro ao abrir o diretório de cache: %v\n", err)
+				os.Exit(exitFailure)
+			}
+		} else {
+			cache = cep.NewCache(*cacheTTL)
+		}
+		if *clearCache {
+			fmt.Fprintf(os.Stderr, "Cache limpo: %d resposta(s) removida(s)\n", cache.Clear())
+		}
+		// A cached answer has nothing to be compared with
+		if *cacheTTL <= 0 || *noCache || *compareOnly {
+			cache = nil
+		} else {
+			clientOpts = append(clientOpts, cep.WithCache(cache))
+		}
+	}
+
+	opts := lookupOptions{
+		client:      cep.New(clientOpts...),
+		cache:       cache,
+		fastestOnly: *fastestOnly && !*compareOnly,
+		diff:        *diff,
+		compareOnly: *compareOnly,
+		checkCity:   *checkCity,
+
+		minProviders: *minProviders,
+		firstN:       *firstN,
+		explain:      *explain,
+		cepStyle:     *formatCEP,
+		attempts:     attempts,
+
+		slowThreshold: *slowThreshold,
+	}
+	if *webhookURL != "" {
+		if u, err := url.Parse(*webhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			usageError(fmt.Sprintf("URL de webhook inválida: %q (use http:// ou https://).", *webhookURL))
+		}
+		if *webhookTimeout <= 0 {
+			usageError("O timeout do webhook deve ser maior que zero.")
+		}
+		opts.webhook = newWebhook(*webhookURL, *webhookTimeout)
+	}
+	if *minProviders < 1 || *minProviders > len(opts.client.Providers()) {
+		usageError(fmt.Sprintf("O número mínimo de APIs deve estar entre 1 e %d.", len(opts.client.Providers())))
+	}
+	if *mode == "fallback" && *minProviders > 1 {
+		usageError("-min-providers só pode ser usado com -mode race.")
+	}
+	if *firstN < 1 || *firstN > len(opts.client.Providers()) {
+		usageError(fmt.Sprintf("O número de APIs de -first-n deve estar entre 1 e %d.", len(opts.client.Providers())))
+	}
+	if *firstN > 1 && (*mode == "fallback" || *minProviders > 1) {
+		usageError("-first-n só pode ser usado com -mode race e sem -min-providers.")
+	}
+	if len(args) == 0 && *serveAddr == "" && !*healthcheck {
 		return
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		resultChan <- Response{APIName: apiName, Error: fmt.Errorf("status code: %d", resp.StatusCode), Duration: time.Since(startTime)}
+	if *healthcheck {
+		code := runHealthcheck(ctx, os.Stdout, opts, *timeout, *format)
+		exitIfInterrupted(ctx)
+		os.Exit(code)
+	}
+
+	if *dryRunFlag {
+		if code := dryRun(os.Stdout, opts.client.Providers(), cepSource(ctx, args, os.Stdin, limit)); code != exitOK {
+			os.Exit(code)
+		}
 		return
 	}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		resultChan <- Response{APIName: apiName, Error: err, Duration: time.Since(startTime)}
+	// Listen before any request so a bad address fails fast
+	if *metricsAddr != "" {
+		ln, err := net.Listen("tcp", *metricsAddr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Erro ao expor as métricas: %v\n", err)
+			os.Exit(exitFailure)
+		}
+		opts.metrics = metrics.NewRegistry()
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", opts.metrics)
+		go http.Serve(ln, mux)
+	}
+
+	if *serveAddr != "" {
+		ln, err := net.Listen("tcp", *serveAddr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Erro ao iniciar o servidor: %v\n", err)
+			os.Exit(exitFailure)
+		}
+		fmt.Fprintf(os.Stderr, "Servidor escutando em http://%s%s{cep} (Ctrl+C para sair)\n", ln.Addr(), cepPath)
+		opts.stats = metrics.NewStats(metrics.DefaultWindow)
+		breakers := withBreakers(opts.client.Providers(), *breakerThreshold, *breakerCooldown)
+		opts.client = cep.New(append(clientOpts, cep.WithProviders(breakers...))...)
+		serveCtx := ctx
+		if *serveCount > 0 {
+			opts.budget = newRequestBudget(*serveCount)
+			var cancel context.CancelFunc
+			serveCtx, cancel = context.WithCancel(ctx)
+			defer cancel()
+			go func() {
+				select {
+				case <-opts.budget.Done():
+					cancel()
+				case <-serveCtx.Done():
+				}
+			}()
+		}
+		if err := serve(serveCtx, ln, newServer(opts)); err != nil {
+			fmt.Fprintf(os.Stderr, "Erro no servidor: %v\n", err)
+			os.Exit(exitFailure)
+		}
+		if opts.webhook != nil {
+			opts.webhook.Wait()
+		}
+		if opts.budget != nil {
+			opts.budget.Summary(os.Stderr)
+		}
 		return
 	}
 
-	var data BrasilAPICEP
-	if err := json.Unmarshal(body, &data); err != nil {
-		resultChan <- Response{APIName: apiName, Error: err, Duration: time.Since(startTime)}
+	if *bench > 0 {
+		ok := runBenchmark(ctx, opts, args[0], *bench, *warmup)
+		exitIfInterrupted(ctx)
+		if !ok {
+			os.Exit(exitFailure)
+		}
 		return
 	}
 
-	duration := time.Since(startTime)
+	b := &batch{
+		opts:        opts,
+		formatter:   formatter,
+		concurrency: *concurrency,
+		output:      outputFile,
+		sortBy:      *sortBy,
+		timeout:     *batchTimeout,
+		deadline:    deadline,
+	}
+
+	code := b.run(ctx, cepSource(ctx, args, os.Stdin, limit))
+	if opts.webhook != nil {
+		opts.webhook.Wait()
+	}
+	exitIfInterrupted(ctx)
+	if code != exitOK {
+		os.Exit(code)
+	}
 
-	// Store timing result
-	mu.Lock()
-	results[apiName] = duration
-	mu.Unlock()
+	// Keep serving the metrics until the process is interrupted
+	if opts.metrics != nil {
+		fmt.Fprintf(os.Stderr, "Métricas disponíveis em http://%s/metrics (Ctrl+C para sair)\n", *metricsAddr)
+		<-ctx.Done()
+	}
+}
 
-	resultChan <- Response{APIName: apiName, Data: data, Duration: duration}
+// exitIfInterrupted exits with exitInterrupted when ctx was canceled by SIGINT
+func exitIfInterrupted(ctx context.Context) {
+	if ctx.Err() != nil {
+		fmt.Fprintln(os.Stderr, "interrompido pelo usuário")
+		os.Exit(exitInterrupted)
+	}
 }
 
-func fetchViaCEP(ctx context.Context, cep string, resultChan chan<- Response, wg *sync.WaitGroup, mu *sync.Mutex, results map[string]time.Duration) {
-	defer wg.Done()
-	startTime := time.Now()
-	apiName := "ViaCEP"
+// newProviders returns the providers raced by the CLI, all sharing client,
+// followed by the ones registered with cep.RegisterProvider
+func newProviders(client *http.Client, brasilAPIURL, viaCEPURL, postmonURL, openCEPURL string) []cep.CEPProvider {
+	return append([]cep.CEPProvider{
+		cep.BrasilAPIProvider{Client: client, BaseURL: brasilAPIURL},
+		cep.ViaCEPProvider{Client: client, BaseURL: viaCEPURL},
+		cep.PostmonProvider{Client: client, BaseURL: postmonURL},
+		cep.OpenCEPProvider{Client: client, BaseURL: openCEPURL},
+	}, cep.RegisteredProviders()...)
+}
 
-	url := fmt.Sprintf("http://viacep.com.br/ws/%s/json/", cep)
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
-	if err != nil {
-		resultChan <- Response{APIName: apiName, Error: err, Duration: time.Since(startTime)}
-		return
+// selectProviders keeps only the providers named in spec, a comma-separated
+// list matched case-insensitively, in their original order. An empty spec
+// keeps them all.
+func selectProviders(providers []cep.CEPProvider, spec string) ([]cep.CEPProvider, error) {
+	names := splitNames(spec)
+	if len(names) == 0 {
+		return providers, nil
 	}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		resultChan <- Response{APIName: apiName, Error: err, Duration: time.Since(startTime)}
-		return
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		if _, ok := findProvider(providers, name); !ok {
+			return nil, fmt.Errorf("API desconhecida em -providers: %q (disponíveis: %s)", name, providerList(providers))
+		}
+		wanted[name] = true
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		resultChan <- Response{APIName: apiName, Error: fmt.Errorf("status code: %d", resp.StatusCode), Duration: time.Since(startTime)}
-		return
+	var selected []cep.CEPProvider
+	for _, p := range providers {
+		if wanted[strings.ToLower(p.Name())] {
+			selected = append(selected, p)
+		}
 	}
+	return selected, nil
+}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		resultChan <- Response{APIName: apiName, Error: err, Duration: time.Since(startTime)}
-		return
+// orderProviders moves the providers named in spec, a comma-separated list
+// matched case-insensitively, to the front in that order. Providers left out
+// keep their relative order after the listed ones.
+func orderProviders(providers []cep.CEPProvider, spec string) ([]cep.CEPProvider, error) {
+	if spec == "" {
+		return providers, nil
 	}
 
-	var data ViaCEP
-	if err := json.Unmarshal(body, &data); err != nil {
-		resultChan <- Response{APIName: apiName, Error: err, Duration: time.Since(startTime)}
-		return
+	var ordered []cep.CEPProvider
+	used := make(map[string]bool, len(providers))
+	for _, name := range splitNames(spec) {
+		p, ok := findProvider(providers, name)
+		if !ok {
+			return nil, fmt.Errorf("API desconhecida em -order: %q (disponíveis: %s)", name, providerList(providers))
+		}
+		if !used[name] {
+			ordered = append(ordered, p)
+			used[name] = true
+		}
 	}
+	for _, p := range providers {
+		if !used[strings.ToLower(p.Name())] {
+			ordered = append(ordered, p)
+		}
+	}
+	return ordered, nil
+}
 
-	duration := time.Since(startTime)
+// splitNames splits a comma-separated list of provider names, lowercasing
+// them and dropping empty entries
+func splitNames(spec string) []string {
+	var names []string
+	for _, name := range strings.Split(spec, ",") {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
 
-	// Store timing result
-	mu.Lock()
-	results[apiName] = duration
-	mu.Unlock()
+// findProvider returns the provider whose lowercased name is name
+func findProvider(providers []cep.CEPProvider, name string) (cep.CEPProvider, bool) {
+	for _, p := range providers {
+		if strings.ToLower(p.Name()) == name {
+			return p, true
+		}
+	}
+	return nil, false
+}
 
-	resultChan <- Response{APIName: apiName, Data: data, Duration: duration}
+// providerList renders the lowercased provider names for error messages
+func providerList(providers []cep.CEPProvider) string {
+	names := make([]string, len(providers))
+	for i, p := range providers {
+		names[i] = strings.ToLower(p.Name())
+	}
+	return strings.Join(names, ", ")
+}
+
+// transportConfig tunes the connections of the shared HTTP client
+type transportConfig struct {
+	ipVersion         string        // "4", "6" or "auto"
+	maxIdleConns      int           // Idle connections kept per API
+	idleConnTimeout   time.Duration // How long an idle connection is kept
+	disableKeepAlives bool          // Open a new connection for every request
+}
+
+// defaultTransport is the configuration used without flags, sized for a
+// busy -serve: every API keeps enough warm connections for 10 concurrent
+// requests
+var defaultTransport = transportConfig{ipVersion: "auto", maxIdleConns: 10, idleConnTimeout: 90 * time.Second}
+
+// newHTTPClient builds the client shared by every provider, so connections
+// and TLS sessions are reused across providers and batch lookups. Proxies
+// from HTTP_PROXY, HTTPS_PROXY and NO_PROXY are honored.
+func newHTTPClient(timeout time.Duration, cfg transportConfig) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// Set explicitly so proxy support doesn't depend on the default transport
+	transport.Proxy = http.ProxyFromEnvironment
+	transport.DialContext = dialer(cfg.ipVersion)
+	transport.MaxIdleConnsPerHost = cfg.maxIdleConns
+	// The providers are a handful of hosts, so the total only has to fit them
+	transport.MaxIdleConns = 10 * cfg.maxIdleConns
+	transport.IdleConnTimeout = cfg.idleConnTimeout
+	transport.DisableKeepAlives = cfg.disableKeepAlives
+
+	return &http.Client{Transport: transport, Timeout: timeout}
+}
+
+// timingDestination returns the writer named by -timing-output: nil for
+// stdout, which keeps the timing along with the result
+func timingDestination(name string) (io.Writer, error) {
+	switch name {
+	case "stdout":
+		return nil, nil
+	case "stderr":
+		return os.Stderr, nil
+	case "none":
+		return io.Discard, nil
+	}
+	return nil, fmt.Errorf("destino do comparativo desconhecido: %q (use stdout, stderr ou none)", name)
+}
+
+// jitterNames lists the values accepted by -retry-jitter
+var jitterNames = []string{cep.JitterNone, cep.JitterFull, cep.JitterDecorrelated}
+
+// ipVersions lists the values accepted by -ip-version
+var ipVersions = []string{"auto", "4", "6"}
+
+// dialer returns a dial function restricted to IPv4 or IPv6 connections for
+// ipVersion "4" or "6". With "auto" both families are tried, racing them
+// as the standard library does (Happy Eyeballs).
+func dialer(ipVersion string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	d := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if ipVersion == "auto" || ipVersion == "" {
+		return d.DialContext
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if network == "tcp" {
+			network += ipVersion
+		}
+		return d.DialContext(ctx, network, addr)
+	}
+}
+
+// contains reports whether list has s
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// envOr returns the value of the environment variable key, or fallback when
+// it is unset or empty
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
 }
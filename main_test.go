@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prodbygus/golang-multithreading/cep"
+)
+
+// mainArgsEnv, when set, makes the test binary run main with its
+// newline-separated arguments instead of the tests; see runMain
+const mainArgsEnv = "CEP_TEST_MAIN_ARGS"
+
+func TestMain(m *testing.M) {
+	if args, ok := os.LookupEnv(mainArgsEnv); ok {
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+		os.Args = append([]string{os.Args[0]}, strings.Split(args, "\n")...)
+		main()
+		os.Exit(exitOK)
+	}
+	os.Exit(m.Run())
+}
+
+// runMain runs the CLI with args in a child process, since main exits, and
+// returns its stdout, stderr and exit code
+func runMain(t *testing.T, args ...string) (stdout, stderr string, code int) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), mainArgsEnv+"="+strings.Join(args, "\n"))
+	var out, errOut bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &errOut
+	err := cmd.Run()
+	var exit *exec.ExitError
+	if err != nil && !errors.As(err, &exit) {
+		t.Fatalf("running main: %v", err)
+	}
+	return out.String(), errOut.String(), cmd.ProcessState.ExitCode()
+}
+
+func TestHTTPClientUsesProxyFromEnvironment(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.Host
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer proxy.Close()
+
+	// http.ProxyFromEnvironment reads the environment only once per process,
+	// so this must run before anything else in the package resolves proxies
+	t.Setenv("HTTP_PROXY", proxy.URL)
+	t.Setenv("NO_PROXY", "")
+
+	resp, err := newHTTPClient(time.Second, defaultTransport).Get("http://cep.invalid/ws/01001000/json/")
+	if err != nil {
+		t.Fatalf("request through proxy failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if proxied != "cep.invalid" {
+		t.Fatalf("got proxied host %q, want %q", proxied, "cep.invalid")
+	}
+}
+
+func TestClearCacheWithoutTTL(t *testing.T) {
+	dir := t.TempDir()
+	c, err := cep.NewDiskCache(dir, time.Hour)
+	if err != nil {
+		t.Fatalf("NewDiskCache: %v", err)
+	}
+	c.Set("01153000", cep.Response{Address: cep.Address{CEP: "01153000", Source: "BrasilAPI"}})
+
+	_, stderr, code := runMain(t, "-clear-cache", "-cache-ttl", "0", "-cache-dir", dir)
+	if code != exitOK {
+		t.Fatalf("exit code = %d, want %d; stderr: %s", code, exitOK, stderr)
+	}
+	if !strings.Contains(stderr, "1 resposta(s) removida(s)") {
+		t.Errorf("stderr = %q, want the number of removed responses", stderr)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*.json")); len(files) != 0 {
+		t.Errorf("files left after -clear-cache -cache-ttl 0: %v", files)
+	}
+}
+
+func TestCompareOnlyRejectsOtherFormats(t *testing.T) {
+	for _, args := range [][]string{
+		{"-format", "json"},
+		{"-json"},
+		{"-quiet"},
+		{"-format", "json", "-pretty"},
+		{"-format", "csv"},
+	} {
+		args := append(args, "-compare-only", "01310100")
+		_, stderr, code := runMain(t, args...)
+		if code != exitUsage || !strings.Contains(stderr, "-compare-only") {
+			t.Errorf("%v: exit code = %d, stderr = %q; want a usage error about -compare-only", args, code, stderr)
+		}
+	}
+}
+
+func TestExitCodeFor(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"success", nil, exitOK},
+		{"invalid CEP", cep.ErrInvalidCEP, exitInvalidCEP},
+		{"timeout", timeoutError{time.Second}, exitTimeout},
+		{"canceled", errCanceled, exitInterrupted},
+		{"not found", &cep.NotFoundError{Provider: "ViaCEP"}, exitFailure},
+		{"all failed", &cep.AllFailedError{}, exitFailure},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitCodeFor(tt.err); got != tt.want {
+				t.Fatalf("exitCodeFor(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOutputCEPFollowsFormatCEP(t *testing.T) {
+	r := lookupResult{input: "01153000", cep: "01153000", cepStyle: "dashed", winner: cep.Response{Address: cep.Address{CEP: "01153-000"}}}
+	if got := newJSONResult(r).CEP; got != "01153-000" {
+		t.Errorf("JSON cep = %q, want 01153-000", got)
+	}
+	if got := resultRow(r, func(time.Duration) string { return "" })[0]; got != "01153-000" {
+		t.Errorf("row cep = %q, want 01153-000", got)
+	}
+
+	invalid := lookupResult{input: "abc", cepStyle: "dashed", err: cep.ErrInvalidCEP}
+	if got := newJSONResult(invalid).CEP; got != "abc" {
+		t.Errorf("JSON cep of an invalid input = %q, want the input", got)
+	}
+}
+
+func TestFlightGroupSharesConcurrentCalls(t *testing.T) {
+	g := newFlightGroup()
+	release := make(chan struct{})
+	var calls int32
+
+	const n = 10
+	var wg sync.WaitGroup
+	shared := make(chan bool, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, ok, err := g.do(context.Background(), "01153000", func(context.Context) lookupResult {
+				atomic.AddInt32(&calls, 1)
+				<-release
+				return lookupResult{cep: "01153000"}
+			})
+			if err != nil || res.cep != "01153000" {
+				t.Errorf("do = %+v, %v", res, err)
+			}
+			shared <- ok
+		}()
+	}
+
+	// Release the call once every caller has joined it
+	for {
+		g.mu.Lock()
+		f := g.flights["01153000"]
+		joined := f != nil && f.waiters == n
+		g.mu.Unlock()
+		if joined {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+	close(shared)
+
+	if calls != 1 {
+		t.Fatalf("fn called %d times, want 1", calls)
+	}
+	sharedCount := 0
+	for ok := range shared {
+		if ok {
+			sharedCount++
+		}
+	}
+	if sharedCount != n-1 {
+		t.Fatalf("%d callers shared the result, want %d", sharedCount, n-1)
+	}
+}
+
+func TestFlightGroupCancelsWhenEveryCallerLeaves(t *testing.T) {
+	g := newFlightGroup()
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled := make(chan struct{})
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	_, _, err := g.do(ctx, "01153000", func(ctx context.Context) lookupResult {
+		<-ctx.Done()
+		close(canceled)
+		return lookupResult{}
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("do error = %v, want context.Canceled", err)
+	}
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("the shared call was not canceled after its only caller left")
+	}
+}
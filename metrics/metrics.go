@@ -0,0 +1,167 @@
+// Package metrics records per-provider latency and race outcomes and exposes
+// them in the Prometheus text format.
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prodbygus/golang-multithreading/cep"
+)
+
+// Buckets are the upper bounds, in seconds, of the duration histogram
+var Buckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// Outcomes counted for every provider response
+const (
+	OutcomeWin     = "wins"
+	OutcomeLoss    = "losses"
+	OutcomeTimeout = "timeouts"
+	OutcomeError   = "errors"
+)
+
+var outcomes = []string{OutcomeWin, OutcomeLoss, OutcomeTimeout, OutcomeError}
+
+// histogram is a cumulative Prometheus-style histogram
+type histogram struct {
+	counts []uint64 // One per bucket, cumulative
+	count  uint64
+	sum    float64
+}
+
+// Registry holds the metrics of every provider. It is safe for concurrent use.
+type Registry struct {
+	mu        sync.Mutex
+	durations map[string]*histogram
+	counters  map[string]map[string]uint64 // outcome -> provider -> count
+}
+
+// NewRegistry returns an empty Registry
+func NewRegistry() *Registry {
+	r := &Registry{
+		durations: make(map[string]*histogram),
+		counters:  make(map[string]map[string]uint64),
+	}
+	for _, o := range outcomes {
+		r.counters[o] = make(map[string]uint64)
+	}
+	return r
+}
+
+// Record adds a single provider response with the given outcome
+func (r *Registry) Record(provider, outcome string, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	h, ok := r.durations[provider]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(Buckets))}
+		r.durations[provider] = h
+	}
+	seconds := d.Seconds()
+	for i, upper := range Buckets {
+		if seconds <= upper {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+
+	r.counters[outcome][provider]++
+}
+
+// Observe records every response read from results and forwards it on the
+// returned channel. The first successful response is counted as the win;
+// later successes and providers canceled after losing count as losses.
+func (r *Registry) Observe(results <-chan cep.Response) <-chan cep.Response {
+	out := make(chan cep.Response, cap(results))
+	go func() {
+		defer close(out)
+		won := false
+		for result := range results {
+			outcome := OutcomeError
+			switch {
+			case result.Error == nil && !won:
+				outcome, won = OutcomeWin, true
+			case result.Error == nil, errors.Is(result.Error, context.Canceled):
+				outcome = OutcomeLoss
+			case errors.Is(result.Error, context.DeadlineExceeded):
+				outcome = OutcomeTimeout
+			}
+			r.Record(result.APIName, outcome, result.Duration)
+			out <- result
+		}
+	}()
+	return out
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	r.WriteTo(w)
+}
+
+// WriteTo writes the metrics in the Prometheus text exposition format
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+
+	fmt.Fprintln(cw, "# HELP cep_provider_request_duration_seconds Duration of provider requests.")
+	fmt.Fprintln(cw, "# TYPE cep_provider_request_duration_seconds histogram")
+	for _, provider := range sortedKeys(r.durations) {
+		h := r.durations[provider]
+		for i, upper := range Buckets {
+			fmt.Fprintf(cw, "cep_provider_request_duration_seconds_bucket{provider=%q,le=\"%g\"} %d\n", provider, upper, h.counts[i])
+		}
+		fmt.Fprintf(cw, "cep_provider_request_duration_seconds_bucket{provider=%q,le=\"+Inf\"} %d\n", provider, h.count)
+		fmt.Fprintf(cw, "cep_provider_request_duration_seconds_sum{provider=%q} %g\n", provider, h.sum)
+		fmt.Fprintf(cw, "cep_provider_request_duration_seconds_count{provider=%q} %d\n", provider, h.count)
+	}
+
+	for _, outcome := range outcomes {
+		name := "cep_provider_" + outcome + "_total"
+		fmt.Fprintf(cw, "# HELP %s Number of provider responses counted as %s.\n", name, outcome)
+		fmt.Fprintf(cw, "# TYPE %s counter\n", name)
+		counts := r.counters[outcome]
+		for _, provider := range sortedKeys(counts) {
+			fmt.Fprintf(cw, "%s{provider=%q} %d\n", name, provider, counts[provider])
+		}
+	}
+
+	return cw.n, cw.err
+}
+
+// countingWriter tracks the bytes written and the first error
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
+
+// sortedKeys returns the keys of m in a stable order for the output
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,129 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prodbygus/golang-multithreading/cep"
+)
+
+// DefaultWindow is the number of recent samples kept per provider
+const DefaultWindow = 1024
+
+// window is a ring buffer holding a provider's most recent durations
+type window struct {
+	samples []time.Duration
+	next    int    // Index overwritten by the next sample once full
+	total   uint64 // Samples ever added, including the evicted ones
+}
+
+func (w *window) add(d time.Duration, size int) {
+	w.total++
+	if len(w.samples) < size {
+		w.samples = append(w.samples, d)
+		return
+	}
+	w.samples[w.next] = d
+	w.next = (w.next + 1) % size
+}
+
+// Stats keeps a rolling window of request durations per provider and
+// reports latency percentiles over it. It is safe for concurrent use.
+type Stats struct {
+	size int
+
+	mu      sync.Mutex
+	windows map[string]*window
+}
+
+// NewStats returns Stats keeping the last size samples of every provider
+func NewStats(size int) *Stats {
+	return &Stats{size: size, windows: make(map[string]*window)}
+}
+
+// Add records a single request duration for provider
+func (s *Stats) Add(provider string, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w, ok := s.windows[provider]
+	if !ok {
+		w = &window{}
+		s.windows[provider] = w
+	}
+	w.add(d, s.size)
+}
+
+// Observe records the duration of every completed response read from
+// results and forwards it on the returned channel. Providers canceled after
+// losing the race or skipped by an open circuit breaker are left out, since
+// their duration says nothing about the provider's latency.
+func (s *Stats) Observe(results <-chan cep.Response) <-chan cep.Response {
+	out := make(chan cep.Response, cap(results))
+	go func() {
+		defer close(out)
+		for result := range results {
+			if !errors.Is(result.Error, context.Canceled) && !errors.Is(result.Error, cep.ErrCircuitOpen) {
+				s.Add(result.APIName, result.Duration)
+			}
+			out <- result
+		}
+	}()
+	return out
+}
+
+// LatencySummary holds the latency percentiles of a provider, in
+// milliseconds, over the samples in its window
+type LatencySummary struct {
+	Count  uint64  `json:"count"`  // Samples ever recorded
+	Window int     `json:"window"` // Samples the percentiles are computed over
+	P50    float64 `json:"p50_ms"`
+	P95    float64 `json:"p95_ms"`
+	P99    float64 `json:"p99_ms"`
+}
+
+// Summary returns the latency percentiles of every provider
+func (s *Stats) Summary() map[string]LatencySummary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make(map[string]LatencySummary, len(s.windows))
+	for provider, w := range s.windows {
+		sorted := append([]time.Duration(nil), w.samples...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		out[provider] = LatencySummary{
+			Count:  w.total,
+			Window: len(sorted),
+			P50:    milliseconds(rank(sorted, 50)),
+			P95:    milliseconds(rank(sorted, 95)),
+			P99:    milliseconds(rank(sorted, 99)),
+		}
+	}
+	return out
+}
+
+// ServeHTTP writes the Summary as JSON
+func (s *Stats) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Summary())
+}
+
+// rank returns the p-th percentile of sorted using the nearest-rank method
+func rank(sorted []time.Duration, p float64) time.Duration {
+	r := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if r < 1 {
+		r = 1
+	}
+	return sorted[r-1]
+}
+
+// milliseconds converts d to fractional milliseconds
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/prodbygus/golang-multithreading/cep"
+)
+
+// auditRecord is a line appended to the -output file for each resolved CEP
+type auditRecord struct {
+	Timestamp  time.Time   `json:"timestamp"`
+	Source     string      `json:"source"`
+	DurationMS float64     `json:"duration_ms"`
+	Address    cep.Address `json:"address"`
+}
+
+// appendRecord writes result to w as a single JSON line
+func appendRecord(w io.Writer, result cep.Response) error {
+	return json.NewEncoder(w).Encode(auditRecord{
+		Timestamp:  time.Now(),
+		Source:     result.APIName,
+		DurationMS: milliseconds(result.Duration),
+		Address:    result.Address,
+	})
+}
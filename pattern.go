@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// rangePattern matches a CEP range such as 01153000-01153010 or
+// 01153-000..01153-010
+var rangePattern = regexp.MustCompile(`^(\d{8})-(\d{8})$|^(\d{5}-?\d{3})\.\.(\d{5}-?\d{3})$`)
+
+// cepPattern is a CEP range or a CEP with * wildcards, each standing for any
+// digit
+type cepPattern struct {
+	from, to int    // Inclusive bounds of a range
+	template string // Wildcard template, empty for ranges
+}
+
+// parsePattern returns the pattern raw denotes; ok is false for plain CEPs
+func parsePattern(raw string) (p cepPattern, ok bool, err error) {
+	if m := rangePattern.FindStringSubmatch(raw); m != nil {
+		a, b := m[1], m[2]
+		if a == "" {
+			a, b = strings.ReplaceAll(m[3], "-", ""), strings.ReplaceAll(m[4], "-", "")
+		}
+		p.from, _ = strconv.Atoi(a)
+		p.to, _ = strconv.Atoi(b)
+		if p.from > p.to {
+			return p, true, fmt.Errorf("intervalo inválido %s: o início é maior que o fim", raw)
+		}
+		return p, true, nil
+	}
+
+	if !strings.Contains(raw, "*") {
+		return p, false, nil
+	}
+	template := strings.ReplaceAll(raw, "-", "")
+	if len(template) != 8 || strings.Trim(template, "0123456789*") != "" {
+		return p, true, fmt.Errorf("padrão inválido %s: use 8 posições com dígitos ou *", raw)
+	}
+	p.template = template
+	return p, true, nil
+}
+
+// size returns how many CEPs p expands to
+func (p cepPattern) size() int {
+	if p.template == "" {
+		return p.to - p.from + 1
+	}
+	n := 1
+	for i := 0; i < strings.Count(p.template, "*"); i++ {
+		n *= 10
+	}
+	return n
+}
+
+// each calls fn with every CEP of p in ascending order until fn returns false
+func (p cepPattern) each(fn func(string) bool) bool {
+	if p.template == "" {
+		for n := p.from; n <= p.to; n++ {
+			if !fn(fmt.Sprintf("%08d", n)) {
+				return false
+			}
+		}
+		return true
+	}
+
+	// Count through the wildcard positions like an odometer
+	stars := strings.Count(p.template, "*")
+	for n := 0; n < p.size(); n++ {
+		digits := fmt.Sprintf("%0*d", stars, n)
+		var b strings.Builder
+		for _, c := range p.template {
+			if c == '*' {
+				b.WriteByte(digits[0])
+				digits = digits[1:]
+			} else {
+				b.WriteRune(c)
+			}
+		}
+		if !fn(b.String()) {
+			return false
+		}
+	}
+	return true
+}
+
+// confirmPatterns checks every pattern in args against maxRange. Larger
+// patterns need confirmation from the terminal, in which case the returned
+// cap is raised to fit them. It fails for invalid or unconfirmed patterns.
+func confirmPatterns(args []string, maxRange int, in *os.File, out io.Writer) (int, error) {
+	for _, arg := range args {
+		p, ok, err := parsePattern(arg)
+		if err != nil {
+			return 0, err
+		}
+		if !ok || p.size() <= maxRange {
+			continue
+		}
+		if !isTerminal(in) {
+			return 0, fmt.Errorf("o padrão %s gera %d CEPs, acima do limite de %d (ajuste -max-range)", arg, p.size(), maxRange)
+		}
+		fmt.Fprintf(out, "O padrão %s gera %d CEPs, acima do limite de %d. Continuar? [s/N] ", arg, p.size(), maxRange)
+		answer, _ := bufio.NewReader(in).ReadString('\n')
+		if a := strings.ToLower(strings.TrimSpace(answer)); a != "s" && a != "sim" {
+			return 0, fmt.Errorf("consulta de %s cancelada", arg)
+		}
+		maxRange = p.size()
+	}
+	return maxRange, nil
+}
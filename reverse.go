@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/prodbygus/golang-multithreading/cep"
+)
+
+// runReverse looks up the CEPs matching a state, city and street with
+// ViaCEP's search and prints them in the selected format. It returns the
+// exit code: exitFailure when the search fails or nothing matches.
+func runReverse(ctx context.Context, w io.Writer, via cep.ViaCEPProvider, timeout time.Duration, format, uf, city, street string) int {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	addrs, err := via.Search(ctx, uf, city, street)
+	if err != nil {
+		switch {
+		case errors.Is(err, cep.ErrInvalidSearch):
+			fmt.Fprintln(w, err)
+			return exitUsage
+		case errors.Is(err, context.DeadlineExceeded):
+			fmt.Fprintf(w, "Erro: Timeout após %s\n", timeout)
+			return exitTimeout
+		}
+		fmt.Fprintf(w, "Erro: %v\n", err)
+		return exitFailure
+	}
+
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		for _, addr := range addrs {
+			enc.Encode(addr)
+		}
+	} else {
+		fmt.Fprintf(w, "Buscando CEPs para: %s, %s - %s\n", street, city, uf)
+		if len(addrs) > 0 {
+			fmt.Fprintf(w, "%d CEP(s) encontrado(s):\n\n", len(addrs))
+			tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(tw, "CEP\tRua\tBairro\tCidade\tUF")
+			for _, a := range addrs {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", a.CEP, a.Street, a.Neighborhood, a.City, a.State)
+			}
+			tw.Flush()
+		}
+	}
+
+	if len(addrs) == 0 {
+		if format != "json" {
+			fmt.Fprintln(w, "Nenhum CEP encontrado.")
+		}
+		return exitFailure
+	}
+	return exitOK
+}
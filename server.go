@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prodbygus/golang-multithreading/cep"
+	"github.com/prodbygus/golang-multithreading/metrics"
+)
+
+// cepPath is the prefix of the lookup endpoint, GET /cep/{code}
+const cepPath = "/cep/"
+
+// statsPath serves the rolling latency percentiles of every provider
+const statsPath = "/stats"
+
+// newServer returns the handler of the -serve mode. Requests share the
+// options, so the HTTP client, cache and metrics are reused, and concurrent
+// requests for the same CEP share a single provider race. opts.stats must
+// be set.
+func newServer(opts lookupOptions) http.Handler {
+	flights := newFlightGroup()
+	mux := http.NewServeMux()
+	mux.HandleFunc(statsPath, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(providerStats(opts))
+	})
+	mux.HandleFunc(cepPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "método não permitido", http.StatusMethodNotAllowed)
+			return
+		}
+		code := strings.TrimPrefix(r.URL.Path, cepPath)
+		if code == "" || strings.Contains(code, "/") {
+			http.NotFound(w, r)
+			return
+		}
+
+		id := r.Header.Get(cep.RequestIDHeader)
+		if id == "" {
+			id = cep.NewRequestID()
+		}
+		w.Header().Set(cep.RequestIDHeader, id)
+
+		start := time.Now()
+		ctx := cep.WithRequestID(r.Context(), id)
+		res := dedupedLookup(ctx, flights, opts, code)
+		elapsed := time.Since(start)
+		opts.notify(res)
+		slog.InfoContext(ctx, "consulta", lookupAttrs(id, res, elapsed)...)
+		if opts.slowThreshold > 0 && elapsed > opts.slowThreshold {
+			// Logged even on success, so a degrading API shows up before
+			// it starts timing out
+			slog.WarnContext(ctx, "consulta lenta", append(lookupAttrs(id, res, elapsed),
+				slog.Float64("threshold_ms", milliseconds(opts.slowThreshold)))...)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusFor(res.err))
+		json.NewEncoder(w).Encode(newJSONResult(res))
+		if opts.budget != nil {
+			opts.budget.Add(time.Since(start), res.err)
+		}
+	})
+	return mux
+}
+
+// dedupedLookup resolves raw, joining the race already in flight for the
+// same normalized CEP if there is one
+func dedupedLookup(ctx context.Context, flights *flightGroup, opts lookupOptions, raw string) lookupResult {
+	code, err := cep.Normalize(raw)
+	if err != nil {
+		return resolveCEP(ctx, opts, raw)
+	}
+	res, _, err := flights.do(ctx, code, func(ctx context.Context) lookupResult {
+		return resolveCEP(ctx, opts, raw)
+	})
+	if err != nil {
+		return lookupResult{input: raw, cep: code, err: errCanceled}
+	}
+	res.input = raw
+	return res
+}
+
+// providerReport is the /stats entry of a provider
+type providerReport struct {
+	metrics.LatencySummary
+	Circuit *cep.BreakerState `json:"circuit,omitempty"` // Nil without a breaker
+}
+
+// providerStats returns the latency percentiles and circuit breaker state
+// of every provider
+func providerStats(opts lookupOptions) map[string]providerReport {
+	reports := make(map[string]providerReport)
+	for name, summary := range opts.stats.Summary() {
+		reports[name] = providerReport{LatencySummary: summary}
+	}
+	for _, p := range opts.client.Providers() {
+		if b, ok := p.(*cep.Breaker); ok {
+			state := b.State()
+			report := reports[p.Name()]
+			report.Circuit = &state
+			reports[p.Name()] = report
+		}
+	}
+	return reports
+}
+
+// withBreakers wraps every provider in a circuit breaker, or returns them
+// unchanged when threshold is zero
+func withBreakers(providers []cep.CEPProvider, threshold int, cooldown time.Duration) []cep.CEPProvider {
+	if threshold <= 0 {
+		return providers
+	}
+	wrapped := make([]cep.CEPProvider, len(providers))
+	for i, p := range providers {
+		wrapped[i] = cep.NewBreaker(p, threshold, cooldown)
+	}
+	return wrapped
+}
+
+// statusFor maps the error of a lookup to the HTTP status of its response
+func statusFor(err error) int {
+	var timeout timeoutError
+	switch {
+	case err == nil:
+		return http.StatusOK
+	case errors.Is(err, cep.ErrInvalidCEP):
+		return http.StatusBadRequest
+	case errors.As(err, &timeout), errors.Is(err, errDeadline):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, errCanceled):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, cep.ErrCEPNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, cep.ErrCircuitOpen):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+// serve runs the HTTP server on ln until ctx is canceled, then waits for
+// in-flight requests to finish
+func serve(ctx context.Context, ln net.Listener, handler http.Handler) error {
+	srv := &http.Server{Handler: handler, ReadHeaderTimeout: 5 * time.Second}
+
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Serve(ln) }()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return srv.Shutdown(shutdownCtx)
+}
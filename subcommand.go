@@ -0,0 +1,184 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+)
+
+// subcommand is a verb given as the first argument, as in "serve :8080".
+// Each one parses its own flag set: the flags of flag.CommandLine minus the
+// ones that select a mode, which the verb replaces, and minus those only
+// another subcommand accepts. Without a verb the whole CommandLine is parsed,
+// so "01153000" and "-serve :8080" keep working.
+type subcommand struct {
+	name     string
+	synopsis string // Positional arguments, for the usage line
+	summary  string
+
+	// only lists the flags of flag.CommandLine no other subcommand accepts
+	only []string
+
+	// define adds the subcommand's own flags to fs and returns what applies
+	// them, along with the positional arguments, to flag.CommandLine
+	define func(fs *flag.FlagSet) func(args []string) ([]string, error)
+}
+
+// modeFlags are the flags of flag.CommandLine replaced by a subcommand
+var modeFlags = []string{"serve", "bench", "reverse", "healthcheck"}
+
+// subcommands lists the verbs, lookup being what runs without one
+var subcommands = []subcommand{
+	{
+		name:     "lookup",
+		synopsis: "<cep> [cep...]",
+		summary:  "consulta um ou mais CEPs em todas as APIs (o padrão sem subcomando)",
+	},
+	{
+		name:     "reverse",
+		synopsis: "<UF> <cidade> <rua>",
+		summary:  "busca os CEPs de um endereço na ViaCEP",
+		define:   setMode("reverse", "true"),
+	},
+	{
+		name:     "bench",
+		synopsis: "<cep>",
+		summary:  "executa várias corridas para o CEP e exibe estatísticas de latência por API",
+		only:     []string{"warmup"},
+		define: func(fs *flag.FlagSet) func([]string) ([]string, error) {
+			runs := fs.Int("n", 10, "número de corridas")
+			return func(args []string) ([]string, error) {
+				if *runs < 1 {
+					return nil, fmt.Errorf("o número de corridas deve ser maior que zero")
+				}
+				return args, flag.Set("bench", strconv.Itoa(*runs))
+			}
+		},
+	},
+	{
+		name:     "serve",
+		synopsis: "[endereço]",
+		summary:  "inicia o servidor HTTP com GET /cep/{código} no endereço (padrão :8080)",
+		only:     []string{"count", "slow-threshold", "breaker-threshold", "breaker-cooldown"},
+		define: func(*flag.FlagSet) func([]string) ([]string, error) {
+			return func(args []string) ([]string, error) {
+				if len(args) > 1 {
+					return nil, fmt.Errorf("aceita apenas o endereço, recebeu %d argumentos", len(args))
+				}
+				addr := ":8080"
+				if len(args) == 1 {
+					addr = args[0]
+				}
+				return nil, flag.Set("serve", addr)
+			}
+		},
+	},
+	{
+		name:    "healthcheck",
+		summary: "consulta um CEP conhecido em cada API e informa se ela está no ar e sua latência",
+		define:  setMode("healthcheck", "true"),
+	},
+}
+
+// setMode returns a subcommand's define that sets the mode flag name to value
+func setMode(name, value string) func(*flag.FlagSet) func([]string) ([]string, error) {
+	return func(*flag.FlagSet) func([]string) ([]string, error) {
+		return func(args []string) ([]string, error) {
+			return args, flag.Set(name, value)
+		}
+	}
+}
+
+// findSubcommand returns the subcommand called name
+func findSubcommand(name string) (subcommand, bool) {
+	for _, sc := range subcommands {
+		if sc.name == name {
+			return sc, true
+		}
+	}
+	return subcommand{}, false
+}
+
+// accepts reports whether the flag of flag.CommandLine called name belongs
+// to sc's flag set
+func (sc subcommand) accepts(name string) bool {
+	if contains(modeFlags, name) {
+		return false
+	}
+	for _, other := range subcommands {
+		if other.name != sc.name && contains(other.only, name) {
+			return false
+		}
+	}
+	return true
+}
+
+// parse parses args with sc's flag set and returns the positional arguments.
+// flag.Usage is replaced by the subcommand's, so usageError describes it.
+func (sc subcommand) parse(args []string) ([]string, error) {
+	fs := flag.NewFlagSet(sc.name, flag.ContinueOnError)
+	flag.CommandLine.VisitAll(func(f *flag.Flag) {
+		if sc.accepts(f.Name) {
+			fs.Var(f.Value, f.Name, f.Usage)
+		}
+	})
+	apply := func(args []string) ([]string, error) { return args, nil }
+	if sc.define != nil {
+		apply = sc.define(fs)
+	}
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Uso: go run main.go %s [flags] %s\n\n%s.\n\nFlags:\n", sc.name, sc.synopsis, capitalize(sc.summary))
+		fs.PrintDefaults()
+	}
+	flag.Usage = fs.Usage
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	// main tells given flags from defaults with flag.Visit, so mark them as
+	// set on flag.CommandLine too; setting a value to itself is harmless
+	fs.Visit(func(f *flag.Flag) {
+		if flag.Lookup(f.Name) != nil {
+			flag.Set(f.Name, f.Value.String())
+		}
+	})
+	rest, err := apply(fs.Args())
+	if err != nil {
+		fmt.Fprintf(fs.Output(), "%s: %v\n", sc.name, err)
+		fs.Usage()
+		return nil, err
+	}
+	return rest, nil
+}
+
+// parseCommandLine parses the program's arguments, by subcommand when the
+// first one names a verb, and returns the positional arguments
+func parseCommandLine(arguments []string) ([]string, error) {
+	if len(arguments) > 0 {
+		if sc, ok := findSubcommand(arguments[0]); ok {
+			return sc.parse(arguments[1:])
+		}
+	}
+	if err := flag.CommandLine.Parse(arguments); err != nil {
+		return nil, err
+	}
+	return flag.Args(), nil
+}
+
+// printSubcommands lists the verbs for the main usage
+func printSubcommands() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Subcomandos (go run main.go <subcomando> -h para as flags de cada um):\n")
+	for _, sc := range subcommands {
+		fmt.Fprintf(out, "  %-12s %s\n", sc.name, sc.summary)
+	}
+	fmt.Fprintln(out)
+}
+
+// capitalize upper-cases the first letter of an ASCII sentence
+func capitalize(s string) string {
+	if s == "" || s[0] < 'a' || s[0] > 'z' {
+		return s
+	}
+	return string(s[0]-'a'+'A') + s[1:]
+}
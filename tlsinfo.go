@@ -0,0 +1,52 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// tlsReport prints the negotiated TLS parameters and the server certificate
+// of each provider, once per provider, for -tls-info
+type tlsReport struct {
+	w io.Writer
+
+	mu   sync.Mutex
+	seen map[string]bool
+}
+
+// newTLSReport returns a tlsReport writing to w
+func newTLSReport(w io.Writer) *tlsReport {
+	return &tlsReport{w: w, seen: make(map[string]bool)}
+}
+
+// Observe is a cep.TLSObserver
+func (r *tlsReport) Observe(provider string, state *tls.ConnectionState) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.seen[provider] {
+		return
+	}
+	r.seen[provider] = true
+	fmt.Fprintf(r.w, "[%s] TLS: %s\n", provider, describeTLS(state))
+}
+
+// describeTLS renders the version, cipher suite and leaf certificate of
+// state, e.g. "TLS 1.3, TLS_AES_128_GCM_SHA256, emitido para viacep.com.br
+// por CN=R11,O=Let's Encrypt,C=US, válido até 2026-03-01 (em 120 dias)"
+func describeTLS(state *tls.ConnectionState) string {
+	s := fmt.Sprintf("%s, %s", tls.VersionName(state.Version), tls.CipherSuiteName(state.CipherSuite))
+	if len(state.PeerCertificates) == 0 {
+		return s + ", sem certificado"
+	}
+	cert := state.PeerCertificates[0]
+	days := int(time.Until(cert.NotAfter).Hours() / 24)
+	expiry := fmt.Sprintf("em %d dias", days)
+	if days < 0 {
+		expiry = "EXPIRADO"
+	}
+	return fmt.Sprintf("%s, emitido para %s por %s, válido até %s (%s)",
+		s, cert.Subject.CommonName, cert.Issuer, cert.NotAfter.Format("2006-01-02"), expiry)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prodbygus/golang-multithreading/cep"
+)
+
+// webhookRetries is the number of extra delivery attempts after a network
+// error or a 5xx/429 answer from the webhook
+const webhookRetries = 2
+
+// webhookBackoff is the wait before the first retry, doubled on each one
+const webhookBackoff = 500 * time.Millisecond
+
+// webhook POSTs every resolved address as JSON to url. Deliveries run in the
+// background so they never slow the lookups down; Wait blocks until the
+// pending ones are done. Failures are logged, not returned.
+type webhook struct {
+	url     string
+	client  *http.Client // Bounds each attempt with its own timeout
+	pending sync.WaitGroup
+}
+
+// newWebhook returns a webhook posting to url, each attempt bounded by timeout
+func newWebhook(url string, timeout time.Duration) *webhook {
+	return &webhook{url: url, client: &http.Client{Timeout: timeout}}
+}
+
+// Send delivers the address of a successful lookup in the background
+func (h *webhook) Send(res lookupResult) {
+	body, err := json.Marshal(res.winner.Address)
+	if err != nil {
+		slog.Warn("falha ao entregar o webhook", "cep", res.cep, "error", err.Error())
+		return
+	}
+	h.pending.Add(1)
+	go func() {
+		defer h.pending.Done()
+		if err := h.deliver(body); err != nil {
+			slog.Warn("falha ao entregar o webhook", "cep", res.cep, "url", h.url, "error", err.Error())
+		}
+	}()
+}
+
+// Wait blocks until every delivery started by Send has finished
+func (h *webhook) Wait() {
+	h.pending.Wait()
+}
+
+// deliver POSTs body, retrying transient failures with exponential backoff
+func (h *webhook) deliver(body []byte) error {
+	backoff := webhookBackoff
+	for attempt := 0; ; attempt++ {
+		retry, err := h.post(body)
+		if err == nil || !retry || attempt >= webhookRetries {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// post makes a single delivery attempt and reports whether a failure is
+// worth retrying
+func (h *webhook) post(body []byte) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", cep.UserAgent)
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		return retry, fmt.Errorf("status code: %d", resp.StatusCode)
+	}
+	return false, nil
+}